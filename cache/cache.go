@@ -1,15 +1,29 @@
 package cache
 
+// Tile is a cached tile along with the notes served with it
+type Tile struct {
+	Data []byte
+
+	// Truncated lists the layers of the tile which were cut short by their feature limit
+	Truncated []string
+
+	// Degraded lists the steps taken to bring the tile within the size budget of its source
+	Degraded []string
+
+	// OverBudget is set when the tile is still over the size budget of its source once every step has been taken
+	OverBudget bool
+}
+
 // Cacher manages a backing cache of tiles
 type Cacher interface {
-	// Set will push the given tile data into the cache optionally returning
+	// Set will push the given tile into the cache optionally returning
 	// an error if the cache is unable to store the value
-	Set(key string, tile []byte) error
+	Set(key string, tile *Tile) error
 
-	// Get will return the tile for the given key - it will return a nil value for the data
+	// Get will return the tile for the given key - it will return a nil tile
 	// if no such key exists. An error is raised if some underlying problem accessing the cache occurs.
 	// This function will not return an error for a non-existent key
-	Get(key string) ([]byte, error)
+	Get(key string) (*Tile, error)
 
 	// Exists checks if there is anything mapped to the given key in the cache
 	Exists(key string) bool
@@ -20,11 +34,11 @@ type Cacher interface {
 
 type noop struct{}
 
-func (m *noop) Set(key string, tile []byte) error {
+func (m *noop) Set(key string, tile *Tile) error {
 	return nil
 }
 
-func (m *noop) Get(key string) ([]byte, error) {
+func (m *noop) Get(key string) (*Tile, error) {
 	return nil, nil
 }
 
@@ -9,21 +9,21 @@ type memcache struct {
 	cache *lru.LRU
 }
 
-func (m *memcache) Set(key string, tile []byte) error {
+func (m *memcache) Set(key string, tile *Tile) error {
 	m.cache.Set(key, tile)
 
-	log.Debugf("Added tile: key = %s, size = %d", key, len(tile))
+	log.Debugf("Added tile: key = %s, size = %d", key, len(tile.Data))
 	return nil
 }
 
-func (m *memcache) Get(key string) ([]byte, error) {
+func (m *memcache) Get(key string) (*Tile, error) {
 	value := m.cache.Get(key)
 
 	if value == nil {
 		return nil, nil
 	}
 
-	return value.([]byte), nil
+	return value.(*Tile), nil
 }
 
 func (m *memcache) Exists(key string) bool {
@@ -40,7 +40,7 @@ func NewMemoryCacher(size int) Cacher {
 		log.WithFields(log.Fields{
 			"tile": key,
 		})
-		log.Debugf("Evicted tile: key = %s, size = %d", key, len(value.([]byte)))
+		log.Debugf("Evicted tile: key = %s, size = %d", key, len(value.(*Tile).Data))
 	}
 	return &memcache{
 		cache: lru.New(size, listener),
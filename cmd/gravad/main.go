@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -18,7 +19,6 @@ import (
 	"github.com/devork/grava/geo"
 	"github.com/devork/grava/web"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/gorilla/mux"
 
 	log "github.com/sirupsen/logrus"
@@ -30,6 +30,18 @@ var (
 	version string
 )
 
+// statusClientClosedRequest is logged for requests abandoned by the client (as used by nginx)
+const statusClientClosedRequest = 499
+
+// truncatedHeader lists the layers of a tile which were cut short by their feature limit
+const truncatedHeader = "X-Grava-Truncated"
+
+// degradedHeader lists the steps taken to bring a tile within the size budget of its source
+const degradedHeader = "X-Grava-Degraded"
+
+// overBudgetHeader gives the size of a tile which is still over the size budget of its source once degraded
+const overBudgetHeader = "X-Grava-Over-Budget"
+
 var (
 	mvtType   = "application/vnd.mapbox-vector-tile"
 	protoType = "application/x-protobuf"
@@ -99,6 +111,7 @@ func main() {
 	router := mux.NewRouter()
 	router.HandleFunc("/status", web.NewStatusHandler("gravad-service"))
 	router.HandleFunc("/sources", web.NewErrorHandler(NewSourceHandler(db)))
+	router.HandleFunc("/stats", web.NewErrorHandler(NewStatsHandler(db)))
 	router.HandleFunc("/{name:[A-Za-z0-9_]+}/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}/tile.mvt", web.NewErrorHandler(NewMVTHandler(db, c)))
 	router.HandleFunc("/fonts/{font}/{file}", web.NewErrorHandler(FontHandler))
 
@@ -199,8 +212,29 @@ func NewSourceHandler(db *data.Db) web.Handler {
 	}
 }
 
+// NewStatsHandler creates a handler type to return the database pool usage to clients
+func NewStatsHandler(db *data.Db) web.Handler {
+	return func(w http.ResponseWriter, r *http.Request) *web.Error {
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		err := json.NewEncoder(w).Encode(db.Stats())
+
+		if err != nil {
+			log.Errorf("failed to write pool stats to client: error = %s", err)
+		}
+
+		return nil
+	}
+}
+
+// fetcher builds the tiles served by the MVT handler, see data.Db
+type fetcher interface {
+	FetchMVT(ctx context.Context, box *geo.BBox, zoom int, name string) (*data.MVT, error)
+}
+
 // NewMVTHandler will create a handler function that is responsible for handling all requests for vector tiles.
-func NewMVTHandler(db *data.Db, cache cache.Cacher) web.Handler {
+func NewMVTHandler(db fetcher, c cache.Cacher) web.Handler {
 	return func(w http.ResponseWriter, r *http.Request) *web.Error {
 
 		vars := mux.Vars(r)
@@ -211,59 +245,84 @@ func NewMVTHandler(db *data.Db, cache cache.Cacher) web.Handler {
 
 		key := fmt.Sprintf("%s_%s_%s_%s", name, vars["x"], vars["y"], vars["z"])
 
-		var data []byte
-		data, err := cache.Get(key)
+		cached, err := c.Get(key)
 
 		if err != nil {
 			log.Errorf("Cache fetch failed: key = %s, error = %s", key, err)
 		}
 
-		if data != nil {
+		if cached != nil {
 			log.Debugf("cache tile fetched: key = %s", key)
-			w.Header().Add("Content-Type", mvtType)
-			w.Header().Add("Content-Length", strconv.Itoa(len(data)))
-			w.WriteHeader(http.StatusOK)
-			w.Write(data)
+			writeTile(w, cached)
 
 			return nil
 		}
 
 		box := geo.NewBBox(x, y, z)
 
-		// get data from bbox
-		tile, err := db.FetchTile(box, name)
+		// get data from bbox, the request context cancels queries if the client goes away
+		tile, err := db.FetchMVT(r.Context(), box, z, name)
 
-		if err != nil {
-			log.Errorf("failed to perform tile query: error = %s", err)
+		if err == data.ErrCancelled {
+			// client has gone away, so nothing to write an error to
+			log.Infof("tile request cancelled: key = %s", key)
+			w.WriteHeader(statusClientClosedRequest)
+			return nil
+		}
+
+		if err == data.ErrTimeout {
+			log.Warnf("tile query timed out: key = %s", key)
 			return &web.Error{
-				Status:  http.StatusInternalServerError,
+				Status:  http.StatusGatewayTimeout,
 				Code:    0,
-				Message: "failed to query data",
+				Message: "tile query timed out",
 			}
 		}
 
-		data, err = proto.Marshal(tile)
-
 		if err != nil {
-			log.Errorf("failed to marshal tile to protobuf: error = %s", err)
+			log.Errorf("failed to perform tile query: error = %s", err)
 			return &web.Error{
 				Status:  http.StatusInternalServerError,
 				Code:    0,
-				Message: "failed to marshal tile to protobuf",
+				Message: "failed to query data",
 			}
 		}
 
-		cache.Set(key, data)
+		// the notes on the tile are kept with it, so a cache hit is served with the same headers
+		fetched := &cache.Tile{
+			Data:       tile.Data,
+			Truncated:  tile.Truncated,
+			Degraded:   tile.Degraded,
+			OverBudget: tile.OverBudget,
+		}
 
-		w.Header().Add("Content-Type", mvtType)
-		w.Header().Add("Content-Length", strconv.Itoa(len(data)))
-		w.WriteHeader(http.StatusOK)
-		w.Write(data)
+		c.Set(key, fetched)
+		writeTile(w, fetched)
 
 		return nil
 	}
 }
 
+// writeTile writes the tile, listing its truncated layers and the steps taken to bring it within budget in the headers
+func writeTile(w http.ResponseWriter, tile *cache.Tile) {
+	if len(tile.Degraded) > 0 {
+		w.Header().Add(degradedHeader, strings.Join(tile.Degraded, ","))
+	}
+
+	if tile.OverBudget {
+		w.Header().Add(overBudgetHeader, strconv.Itoa(len(tile.Data)))
+	}
+
+	if len(tile.Truncated) > 0 {
+		w.Header().Add(truncatedHeader, strings.Join(tile.Truncated, ","))
+	}
+
+	w.Header().Add("Content-Type", mvtType)
+	w.Header().Add("Content-Length", strconv.Itoa(len(tile.Data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(tile.Data)
+}
+
 // NotFounderHandler provides extra logging when no route matches
 func NotFounderHandler(w http.ResponseWriter, r *http.Request) {
 	log.Warnf("cannot find handler for route: route = %s", r.RequestURI)
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/devork/grava/cache"
+	"github.com/devork/grava/data"
+	"github.com/devork/grava/geo"
+	"github.com/devork/grava/web"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+// tiles serves a fixed tile, counting the fetches
+type tiles struct {
+	tile    *data.MVT
+	fetches int
+}
+
+func (t *tiles) FetchMVT(ctx context.Context, box *geo.BBox, zoom int, name string) (*data.MVT, error) {
+	t.fetches++
+	return t.tile, nil
+}
+
+func TestMVTHandlerCached(t *testing.T) {
+	db := &tiles{tile: &data.MVT{
+		Data:       []byte{0x1a, 0x00},
+		Truncated:  []string{"building", "road"},
+		Degraded:   []string{"simplify", "attributes"},
+		OverBudget: true,
+	}}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{name}/{z}/{x}/{y}/tile.mvt", web.NewErrorHandler(NewMVTHandler(db, cache.NewMemoryCacher(10))))
+
+	// the second request is served from the cache, with the same headers as the first
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/os/14/8100/5400/tile.mvt", nil))
+
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Equal(t, []byte{0x1a, 0x00}, w.Body.Bytes())
+		require.Equal(t, "building,road", w.Header().Get(truncatedHeader))
+		require.Equal(t, "simplify,attributes", w.Header().Get(degradedHeader))
+		require.Equal(t, "2", w.Header().Get(overBudgetHeader))
+	}
+
+	require.Equal(t, 1, db.fetches)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -26,6 +27,7 @@ type Config struct {
 	Cache    Cache    `json:"cache"`
 	Logging  Logging  `json:"logging"`
 	Postgres string   `json:"postgres"`
+	Database Database `json:"database"`
 	Schema   string   `json:"schema"`
 	Sources  []Source `json:"sources"`
 	FontsDir string   `json:"fontsDir"`
@@ -38,6 +40,27 @@ type Logging struct {
 	Level string `json:"level"`
 }
 
+// Database holds the connection pool and session settings. Session settings are sent to Postgres as run-time
+// parameters on every new connection.
+//
+//  "database": {
+//      "maxConnections": 20,
+//      "acquireTimeout": "2s",
+//      "statementTimeout": "10s",
+//      "applicationName": "gravad",
+//      "searchPath": "grava,public",
+//      "logLevel": "warn"
+//  }
+//
+type Database struct {
+	MaxConnections   int      `json:"maxConnections"`
+	AcquireTimeout   Duration `json:"acquireTimeout"`
+	StatementTimeout Duration `json:"statementTimeout"`
+	ApplicationName  string   `json:"applicationName"`
+	SearchPath       string   `json:"searchPath"`
+	LogLevel         string   `json:"logLevel"`
+}
+
 // Server holds the web server configuration
 type Server struct {
 	Port int  `json:"port"`
@@ -53,20 +76,328 @@ type Cache struct {
 // Source configures a set of layers to be displayed in a vector map. A source is composed of a name (which must be unique in the set of
 // sources configured), a URI (which points to a datasource) and the set of layers to query.
 //
+// Layers can either be given as a table name or as an object (see Layer) where further options are needed.
+//
+// The backend chooses how tiles are built: "go" (the default) reads the geometry and attribute rows and encodes the
+// tile in grava, "postgis" has PostGIS build each layer with ST_AsMVT (PostGIS 3+). Generalization is only applied by
+// the go backend.
+//
+// The layers of a tile are queried concurrently, with at most Parallelism (default 4) queries per tile at a time. The
+// Timeout limits the time taken to query all layers of a tile, e.g. "5s" (no limit by default).
+//
+// Geometries are clipped to the tile extent plus an optional buffer (in tile pixels, default 64) so that lines and labels
+// are not cut off at the tile edges. The extent sets the tile resolution (default 4096) and the limit the maximum number
+// of features read per layer (default 20000, 0 for no limit). Layers can override all three.
+//
+// MaxSize caps the size of an encoded tile in bytes (no limit by default). Tiles over the size are degraded step by
+// step: lines and polygons are simplified, low priority attributes dropped, then the smallest features and finally the
+// optional layers (see Layer).
+//
 // Postgis Database:
 //
 //  {
 //      "name": "opmplc_su",
+//      "buffer": 64,
+//      "extent": 4096,
+//      "limit": 50000,
 //      "layers": [
 //          "namedplace",
-//          "building"
+//          {"name": "building", "id": "fid"}
 //      ]
 //  }
 //
 type Source struct {
+	Prefix      string   `json:"prefix"`
+	Name        string   `json:"name"`
+	Backend     string   `json:"backend"`
+	Buffer      *uint32  `json:"buffer"`
+	Extent      uint32   `json:"extent"`
+	Limit       *int     `json:"limit"`
+	MaxSize     int      `json:"maxSize"`
+	Parallelism int      `json:"parallelism"`
+	Timeout     Duration `json:"timeout"`
+	Layers      []Layer  `json:"layers"`
+}
+
+// Layer configures a single table within a source.
+//
+// The ID names the column used for the feature id - integer columns are used as is, text columns are hashed. When
+// no ID is set, the table's primary key is used (if there is one).
+//
+// The Timeout limits the time taken by the layer query, e.g. "500ms" (no limit by default). Buffer, Extent and Limit
+// override the values of the source for this layer.
+//
+// Columns are served with a type based on their Postgres type. Types override this for individual columns, e.g. a
+// timestamp as seconds since the epoch rather than an ISO 8601 string, or a numeric as a string:
+//
+//  {
+//      "name": "building",
+//      "types": {"updated": "integer", "height": "string"}
+//  }
+//
+// Point layers can be clustered at low zooms, see Cluster, or aggregated into cells, see Bins. Polygon layers can be
+// served with a layer of label points, see Labels. Lines and polygons can be merged by attribute at low zooms, see
+// Merge.
+//
+// Optional layers are left out of tiles which are over the size budget of the source, once all other steps to reduce
+// the size of the tile have been taken.
+//
+// Precision rounds float attributes to a number of decimal places (0 to 15) and MaxLength cuts string attributes to a
+// number of characters, reducing the size of the tiles.
+//
+// Tables may be stored in any SRID, geometries are transformed to the tile SRID (3857) when read.
+//
+// Instead of serving a table, a layer can be given its own SQL statement. The statement must return a geometry column
+// (in the tile SRID) and should filter on the tile box itself; the tokens !BBOX!, !ZOOM!, !PIXEL_WIDTH! and
+// !SCALE_DENOMINATOR! are replaced with bind parameters for each tile, e.g.
+//
+//  {
+//      "name": "road",
+//      "sql": "select geometry, classification from grava.opmplc_road where geometry && !BBOX! and !ZOOM! >= 12"
+//  }
+//
+//
+// MinZoom and MaxZoom limit the zooms a layer is served at (inclusive), outside of that range the layer is left out of
+// the tile without querying the database. Filters add a SQL condition on the layer's columns for a range of zooms,
+// e.g. only showing major roads at low zoom:
+//
+//  {
+//      "name": "road",
+//      "minzoom": 8,
+//      "filter": [
+//          {"maxzoom": 11, "where": "classification in ('Motorway', 'A Road')"}
+//      ]
+//  }
+//
+type Layer struct {
+	Name       string            `json:"name"`
+	ID         string            `json:"id"`
+	SQL        string            `json:"sql"`
+	Timeout    Duration          `json:"timeout"`
+	Buffer     *uint32           `json:"buffer"`
+	Extent     uint32            `json:"extent"`
+	Limit      *int              `json:"limit"`
+	MinZoom    *int              `json:"minzoom"`
+	MaxZoom    *int              `json:"maxzoom"`
+	Filter     []Filter          `json:"filter"`
+	Generalize *Generalize       `json:"generalize"`
+	Types      map[string]string `json:"types"`
+	Expand     []Expand          `json:"expand"`
+	Attributes Attributes        `json:"attributes"`
+	Precision  *int              `json:"precision"`
+	MaxLength  int               `json:"maxLength"`
+	Cluster    *Cluster          `json:"cluster"`
+	Bins       *Bins             `json:"bins"`
+	Labels     *Labels           `json:"labels"`
+	Merge      *Merge            `json:"merge"`
+	Optional   bool              `json:"optional"`
+}
+
+// Attributes chooses the attributes served for a layer. Columns are served unless Include is given (then only those
+// columns are), less those in Exclude. Rename maps a column to the name it is served as. Computed attributes are SQL
+// expressions on the layer's columns, served as the given type ("string" by default). Zoom limits attributes (by the
+// name they are served as) to a range of zooms. LowPriority attributes (also by name) are dropped from tiles over the
+// size budget of the source.
+//
+//  {
+//      "name": "road",
+//      "attributes": {
+//          "exclude": ["created_by", "updated_by"],
+//          "rename": {"classification": "class"},
+//          "computed": [{"name": "label", "sql": "name_en || ' ' || ref"}],
+//          "zoom": {"label": {"minzoom": 14}}
+//      }
+//  }
+//
+type Attributes struct {
+	Include     []string             `json:"include"`
+	Exclude     []string             `json:"exclude"`
+	Rename      map[string]string    `json:"rename"`
+	Computed    []Computed           `json:"computed"`
+	Zoom        map[string]ZoomRange `json:"zoom"`
+	LowPriority []string             `json:"lowPriority"`
+}
+
+// Computed is an attribute calculated with a SQL expression, e.g. "round(height)"
+type Computed struct {
+	Name string `json:"name"`
+	SQL  string `json:"sql"`
+	Type string `json:"type"`
+}
+
+// ZoomRange is an inclusive range of zooms, a missing bound leaves that end of the range open
+type ZoomRange struct {
+	MinZoom *int `json:"minzoom"`
+	MaxZoom *int `json:"maxzoom"`
+}
+
+// Expand flattens the top level keys of a json, jsonb or hstore column into individual attributes. Only the keys in
+// Allow are kept (all keys if empty), less those in Deny. The Prefix is added to each key, e.g. "tag:". Nested objects
+// and arrays are served as json strings.
+//
+//  {
+//      "name": "building",
+//      "expand": [
+//          {"column": "tags", "prefix": "tag:", "deny": ["fixme", "note"]}
+//      ]
+//  }
+//
+type Expand struct {
+	Column string   `json:"column"`
 	Prefix string   `json:"prefix"`
-	Name   string   `json:"name"`
-	Layers []string `json:"layers"`
+	Allow  []string `json:"allow"`
+	Deny   []string `json:"deny"`
+}
+
+// Filter restricts the features of a layer with a SQL condition, applied to the zooms between MinZoom and MaxZoom
+// (inclusive). A missing bound leaves that end of the range open.
+type Filter struct {
+	MinZoom *int   `json:"minzoom"`
+	MaxZoom *int   `json:"maxzoom"`
+	Where   string `json:"where"`
+}
+
+// Generalize configures the simplification of a layer's geometries. All sizes are given in tile pixels (units of the
+// tile extent) so the amount of ground detail removed follows the zoom of the tile being requested.
+//
+// Coordinates are snapped to the Grid and lines/rings simplified (Douglas-Peucker) with the given Tolerance. Lines
+// shorter than MinLength and polygons (or holes) with an area below MinArea are dropped. Generalization is applied at
+// every zoom unless MaxZoom is set.
+//
+//  {
+//      "name": "building",
+//      "generalize": {"maxzoom": 15, "tolerance": 4, "minArea": 64}
+//  }
+//
+type Generalize struct {
+	MaxZoom   *int    `json:"maxzoom"`
+	Tolerance float64 `json:"tolerance"`
+	Grid      float64 `json:"grid"`
+	MinArea   float64 `json:"minArea"`
+	MinLength float64 `json:"minLength"`
+}
+
+// Cluster groups the points of a layer which are close together in the tile, replacing each group with a single point
+// at the centre of the group. Mode is either "grid" (the default), grouping the points in square cells of Radius tile
+// pixels, or "distance", grouping points within Radius pixels of the most important point not yet in a group.
+//
+// Points are ranked by the (numeric) Priority attribute, highest first. A group of points is served with its size as
+// "point_count" and the Aggregates of the group's attributes, by the tile holding the centre of the group. Clustering
+// is applied at every zoom unless MaxZoom is set.
+//
+//  {
+//      "name": "namedplace",
+//      "cluster": {
+//          "maxzoom": 10,
+//          "mode": "distance",
+//          "radius": 128,
+//          "priority": "population",
+//          "aggregates": [
+//              {"column": "population", "function": "sum"},
+//              {"name": "name", "column": "name", "function": "first"}
+//          ]
+//      }
+//  }
+//
+type Cluster struct {
+	MaxZoom    *int        `json:"maxzoom"`
+	Mode       string      `json:"mode"`
+	Radius     float64     `json:"radius"`
+	Priority   string      `json:"priority"`
+	Aggregates []Aggregate `json:"aggregates"`
+}
+
+// Bins turns a point layer into a layer of cells, each cell being served as a polygon with the number of points in the
+// cell ("count") and the Aggregates of their attributes. The Shape is either "square" (the default) or "hexagon" and
+// the Size is the width of a cell in tile pixels (between opposite corners for hexagons). A layer given its own SQL
+// can aggregate a table served by another layer:
+//
+//  {
+//      "name": "namedplace_density",
+//      "sql": "select geometry, population from grava.opmplc_namedplace where geometry && !BBOX!",
+//      "bins": {
+//          "shape": "hexagon",
+//          "size": 256,
+//          "aggregates": [{"column": "population", "function": "avg"}]
+//      }
+//  }
+//
+type Bins struct {
+	Shape      string      `json:"shape"`
+	Size       float64     `json:"size"`
+	Aggregates []Aggregate `json:"aggregates"`
+}
+
+// Labels serves a point per polygon of the layer in an extra layer, named Layer ("<layer>_label" by default). The point
+// is placed with Method "inaccessibility" (the default: the point furthest inside the polygon) or "surface" (a point
+// on the surface, quicker to find). Points are found from the whole polygon and only served in the tile holding them,
+// so each polygon is labelled once however many tiles it spans. The label points have the id and attributes of their
+// polygon.
+//
+//  {
+//      "name": "importantbuilding",
+//      "labels": {"method": "inaccessibility"}
+//  }
+//
+type Labels struct {
+	Layer  string `json:"layer"`
+	Method string `json:"method"`
+}
+
+// Merge combines the lines (and polygons) of a layer which have the same values for the Keys attributes into a single
+// feature: touching lines are joined into longer lines and polygons sharing edges are unioned. Merged features only
+// have the key attributes. Features are merged at every zoom unless MaxZoom is set.
+//
+//  {
+//      "name": "road",
+//      "merge": {"maxzoom": 11, "keys": ["classification"]}
+//  }
+//
+type Merge struct {
+	MaxZoom *int     `json:"maxzoom"`
+	Keys    []string `json:"keys"`
+}
+
+// Aggregate summarises an attribute over a group of features with one of the functions "count" (of the features with
+// a value), "sum", "avg", "min", "max" or "first" (the value of the highest priority feature). The Name of the result
+// defaults to the column and function, e.g. "population_sum".
+type Aggregate struct {
+	Name     string `json:"name"`
+	Column   string `json:"column"`
+	Function string `json:"function"`
+}
+
+// UnmarshalJSON allows a layer to be given as just the table name
+func (l *Layer) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*l = Layer{Name: name}
+		return nil
+	}
+
+	// alias type prevents recursing back into this function
+	type layer Layer
+	return json.Unmarshal(b, (*layer)(l))
+}
+
+// Duration is a time.Duration given as a string in the configuration, e.g. "1.5s" or "300ms"
+type Duration time.Duration
+
+// UnmarshalJSON parses the duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return fmt.Errorf("duration must be a string, e.g. \"5s\": %s", err)
+	}
+
+	v, err := time.ParseDuration(str)
+
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+	return nil
 }
 
 // New will read config from the specified path
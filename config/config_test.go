@@ -0,0 +1,23 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceLayers(t *testing.T) {
+	var source Source
+
+	err := json.Unmarshal([]byte(`{
+		"name": "opmplc",
+		"layers": [
+			"namedplace",
+			{"name": "building", "id": "fid"}
+		]
+	}`), &source)
+
+	require.NoError(t, err)
+	require.Equal(t, []Layer{{Name: "namedplace"}, {Name: "building", ID: "fid"}}, source.Layers)
+}
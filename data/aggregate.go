@@ -0,0 +1,167 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/devork/grava/config"
+)
+
+// Aggregate functions, see config.Aggregate
+const (
+	AggregateCount = "count"
+	AggregateSum   = "sum"
+	AggregateAvg   = "avg"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+	AggregateFirst = "first"
+)
+
+// aggregate is a validated config.Aggregate
+type aggregate struct {
+	name     string
+	column   string
+	function string
+}
+
+// newAggregates validates the configured aggregates against the attributes of the layer, naming those without a name
+func newAggregates(layer string, cfg []config.Aggregate, attrs []Attribute) ([]aggregate, error) {
+	aggs := make([]aggregate, 0, len(cfg))
+
+	for _, a := range cfg {
+		switch a.Function {
+		case AggregateCount, AggregateSum, AggregateAvg, AggregateMin, AggregateMax, AggregateFirst:
+		default:
+			return nil, fmt.Errorf("unknown aggregate function: layer = %s, column = %s, function = %s", layer, a.Column, a.Function)
+		}
+
+		if a.Column == "" {
+			return nil, fmt.Errorf("aggregate needs a column: layer = %s, function = %s", layer, a.Function)
+		}
+
+		if !hasAttribute(attrs, a.Column) {
+			return nil, fmt.Errorf("aggregate column not found: layer = %s, column = %s", layer, a.Column)
+		}
+
+		name := a.Name
+		if name == "" {
+			name = a.Column + "_" + a.Function
+		}
+
+		aggs = append(aggs, aggregate{name, a.Column, a.Function})
+	}
+
+	return aggs, nil
+}
+
+// hasAttribute checks if the attributes include the named one
+func hasAttribute(attrs []Attribute, name string) bool {
+	for _, attr := range attrs {
+		if attr.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// attribute describes the result of the aggregate given the layer's attributes: counts are integers and averages
+// floats, other functions keep the type of their column
+func (a aggregate) attribute(attrs []Attribute) Attribute {
+	typ := TypeFloat
+
+	switch a.function {
+	case AggregateCount:
+		typ = TypeInteger
+	case AggregateAvg:
+	default:
+		for _, attr := range attrs {
+			if attr.Name == a.column {
+				typ = attr.Type
+			}
+		}
+	}
+
+	return Attribute{Name: a.name, Type: typ}
+}
+
+// apply computes the aggregate over the features, returning nil when none of the features have a value. Numeric
+// functions skip values which aren't numbers; sums, minimums and maximums of integers stay integers.
+func (a aggregate) apply(features []*feature) interface{} {
+	var count int64
+	var sum float64
+	var isum int64
+	var best interface{}
+	integers := true
+
+	for _, f := range features {
+		v := f.prop(a.column)
+
+		if v == nil {
+			continue
+		}
+
+		if a.function == AggregateFirst {
+			return v
+		}
+
+		n, ok := number(v)
+
+		if !ok {
+			if a.function == AggregateCount {
+				count++
+			}
+			continue
+		}
+
+		i, integer := v.(int64)
+		integers = integers && integer
+
+		switch a.function {
+		case AggregateMin:
+			if best == nil || n < mustNumber(best) {
+				best = v
+			}
+		case AggregateMax:
+			if best == nil || n > mustNumber(best) {
+				best = v
+			}
+		}
+
+		count++
+		sum += n
+		isum += i
+	}
+
+	if count == 0 {
+		return nil
+	}
+
+	switch a.function {
+	case AggregateCount:
+		return count
+	case AggregateSum:
+		if integers {
+			return isum
+		}
+		return sum
+	case AggregateAvg:
+		return sum / float64(count)
+	}
+
+	return best
+}
+
+// number reads an attribute value as a float, attributes being read as int64 or float64 values
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func mustNumber(v interface{}) float64 {
+	n, _ := number(v)
+	return n
+}
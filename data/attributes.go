@@ -0,0 +1,201 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/devork/grava/config"
+	"github.com/jackc/pgx"
+	log "github.com/sirupsen/logrus"
+)
+
+// Attribute details a field value in a layer. Attributes only served for a range of zooms give the range.
+type Attribute struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	MinZoom *int   `json:"minzoom,omitempty"`
+	MaxZoom *int   `json:"maxzoom,omitempty"`
+}
+
+// casts convert a computed attribute (with %[1]s being the expression) into its type
+var casts = map[string]string{
+	TypeInteger: "(%[1]s)::int8",
+	TypeFloat:   "(%[1]s)::float8",
+	TypeBoolean: "(%[1]s)::bool",
+	TypeString:  "(%[1]s)::text",
+}
+
+// selection holds the attributes of a layer and how they are read
+type selection struct {
+	attrs     []Attribute
+	selects   string
+	expanders map[string]*expander
+}
+
+// selectAttributes works out the attributes of a layer from its columns and configuration, skipping the geometry and
+// id columns. Attributes are cast in SQL to the type they are served as, so are read as integer, float, boolean or
+// string values. Attributes outside of their zoom range are read as null, which leaves them out of the tile.
+func selectAttributes(layer string, columns []column, skip map[string]bool, lyr config.Layer) (*selection, error) {
+	cfg := lyr.Attributes
+
+	known := map[string]column{}
+	for _, c := range columns {
+		known[c.name] = c
+	}
+
+	check := func(what string, cols ...string) error {
+		for _, col := range cols {
+			if _, ok := known[col]; !ok {
+				return fmt.Errorf("%s column not found: layer = %s, column = %s", what, layer, col)
+			}
+		}
+		return nil
+	}
+
+	for col := range lyr.Types {
+		if err := check("type", col); err != nil {
+			return nil, err
+		}
+	}
+
+	for col := range cfg.Rename {
+		if err := check("rename", col); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := check("include", cfg.Include...); err != nil {
+		return nil, err
+	}
+
+	if err := check("exclude", cfg.Exclude...); err != nil {
+		return nil, err
+	}
+
+	sel := &selection{expanders: map[string]*expander{}}
+
+	for _, e := range lyr.Expand {
+		if err := check("expand", e.Column); err != nil {
+			return nil, err
+		}
+
+		if udt := known[e.Column].udt; expansions[udt] == "" {
+			return nil, fmt.Errorf("expand column is not json, jsonb or hstore: layer = %s, column = %s, type = %s", layer, e.Column, udt)
+		}
+
+		sel.expanders[e.Column] = newExpander(e)
+	}
+
+	include := set(cfg.Include)
+	exclude := set(cfg.Exclude)
+	names := map[string]bool{}
+
+	add := func(name, typ, expr string) error {
+		if names[name] {
+			return fmt.Errorf("duplicate attribute name: layer = %s, name = %s", layer, name)
+		}
+		names[name] = true
+
+		attr := Attribute{Name: name, Type: typ}
+
+		if zoom, ok := cfg.Zoom[name]; ok {
+			attr.MinZoom = zoom.MinZoom
+			attr.MaxZoom = zoom.MaxZoom
+			expr = visible(expr, zoom)
+		}
+
+		sel.attrs = append(sel.attrs, attr)
+		sel.selects += fmt.Sprintf(", %s as %s", expr, pgx.Identifier{name}.Sanitize())
+		return nil
+	}
+
+	for _, c := range columns {
+		if skip[c.name] {
+			continue
+		}
+
+		ident := pgx.Identifier{c.name}.Sanitize()
+
+		// expanded columns are read as json text, the keys being known only once read
+		if _, ok := sel.expanders[c.name]; ok {
+			names[c.name] = true
+			sel.selects += fmt.Sprintf(", %s as %s", fmt.Sprintf(expansions[c.udt], ident), ident)
+			continue
+		}
+
+		if (len(include) > 0 && !include[c.name]) || exclude[c.name] {
+			continue
+		}
+
+		m, ok := lookup(c)
+
+		if !ok {
+			log.Debugf("ignoring column, unsupported type: layer = %s, column = %s, type = %s", layer, c.name, c.udt)
+			continue
+		}
+
+		typ, expr, err := m.read(c, ident, lyr.Types[c.name])
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid attribute type: layer = %s, err = %s", layer, err)
+		}
+
+		name := c.name
+		if rename, ok := cfg.Rename[c.name]; ok {
+			name = rename
+		}
+
+		if err = add(name, typ, expr); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, c := range cfg.Computed {
+		if c.Name == "" || c.SQL == "" {
+			return nil, fmt.Errorf("computed attribute needs a name and sql: layer = %s", layer)
+		}
+
+		typ := c.Type
+		if typ == "" {
+			typ = TypeString
+		}
+
+		cast, ok := casts[typ]
+
+		if !ok {
+			return nil, fmt.Errorf("unknown computed attribute type: layer = %s, name = %s, type = %s", layer, c.Name, typ)
+		}
+
+		if err := add(c.Name, typ, fmt.Sprintf(cast, c.SQL)); err != nil {
+			return nil, err
+		}
+	}
+
+	for name := range cfg.Zoom {
+		if !names[name] {
+			return nil, fmt.Errorf("zoom range given for unknown attribute: layer = %s, name = %s", layer, name)
+		}
+	}
+
+	return sel, nil
+}
+
+// visible limits the expression to the zoom range, being null elsewhere
+func visible(expr string, zoom config.ZoomRange) string {
+	switch {
+	case zoom.MinZoom != nil && zoom.MaxZoom != nil:
+		return fmt.Sprintf("case when %s between %d and %d then %s end", TokenZoom, *zoom.MinZoom, *zoom.MaxZoom, expr)
+	case zoom.MinZoom != nil:
+		return fmt.Sprintf("case when %s >= %d then %s end", TokenZoom, *zoom.MinZoom, expr)
+	case zoom.MaxZoom != nil:
+		return fmt.Sprintf("case when %s <= %d then %s end", TokenZoom, *zoom.MaxZoom, expr)
+	}
+	return expr
+}
+
+func set(values []string) map[string]bool {
+	out := make(map[string]bool, len(values))
+	for _, v := range values {
+		out[v] = true
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/devork/grava/config"
+	"github.com/stretchr/testify/require"
+)
+
+var attributeColumns = []column{
+	{name: "fid", udt: "int8"},
+	{name: "geometry", udt: "geometry"},
+	{name: "name", udt: "text"},
+	{name: "classification", udt: "varchar"},
+	{name: "height", udt: "numeric"},
+	{name: "updated_by", udt: "text"},
+}
+
+var attributeSkips = map[string]bool{"fid": true, "geometry": true}
+
+func TestSelectAttributes(t *testing.T) {
+	min := 14
+
+	sel, err := selectAttributes("building", attributeColumns, attributeSkips, config.Layer{
+		Attributes: config.Attributes{
+			Exclude:  []string{"updated_by"},
+			Rename:   map[string]string{"classification": "class"},
+			Computed: []config.Computed{{Name: "levels", SQL: "round(height / 3)", Type: "integer"}},
+			Zoom:     map[string]config.ZoomRange{"name": {MinZoom: &min}},
+		},
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, []Attribute{
+		{Name: "name", Type: TypeString, MinZoom: &min},
+		{Name: "class", Type: TypeString},
+		{Name: "height", Type: TypeFloat},
+		{Name: "levels", Type: TypeInteger},
+	}, sel.attrs)
+	require.Equal(t,
+		`, case when !ZOOM! >= 14 then "name"::text end as "name"`+
+			`, "classification"::text as "class"`+
+			`, "height"::float8 as "height"`+
+			`, (round(height / 3))::int8 as "levels"`,
+		sel.selects,
+	)
+}
+
+func TestSelectAttributesInclude(t *testing.T) {
+	sel, err := selectAttributes("building", attributeColumns, attributeSkips, config.Layer{
+		Attributes: config.Attributes{Include: []string{"name", "height"}},
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, []Attribute{{Name: "name", Type: TypeString}, {Name: "height", Type: TypeFloat}}, sel.attrs)
+}
+
+func TestSelectAttributesInvalid(t *testing.T) {
+	for _, cfg := range []config.Attributes{
+		{Include: []string{"missing"}},
+		{Rename: map[string]string{"missing": "other"}},
+		{Rename: map[string]string{"classification": "name"}},
+		{Computed: []config.Computed{{Name: "levels", SQL: "1", Type: "number"}}},
+		{Zoom: map[string]config.ZoomRange{"missing": {}}},
+	} {
+		_, err := selectAttributes("building", attributeColumns, attributeSkips, config.Layer{Attributes: cfg})
+		require.Error(t, err)
+	}
+}
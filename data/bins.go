@@ -0,0 +1,195 @@
+package data
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/devork/geom"
+	"github.com/devork/grava/config"
+)
+
+// Bin shapes, see config.Bins
+const (
+	BinSquare  = "square"
+	BinHexagon = "hexagon"
+)
+
+// binCount is the attribute holding the number of points in a cell
+const binCount = "count"
+
+// binner aggregates the points of a layer into square or hexagonal cells in tile space. The cells are laid over the
+// world rather than the tile, so neighbouring tiles agree on them, and the layer is read a cell past its buffer (see
+// Layer.margin) so the cells on the edge of the tile count all of their points.
+type binner struct {
+	shape      string
+	size       float64
+	aggregates []aggregate
+}
+
+// group is the content of a cell: the number of points and the features holding them
+type group struct {
+	count    int64
+	features []*feature
+}
+
+// newBinner validates the bins configuration of a layer against its attributes, returning nil if the layer isn't
+// binned
+func newBinner(layer string, cfg *config.Bins, attrs []Attribute) (*binner, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	shape := cfg.Shape
+	if shape == "" {
+		shape = BinSquare
+	}
+
+	if shape != BinSquare && shape != BinHexagon {
+		return nil, fmt.Errorf("unknown bin shape: layer = %s, shape = %s", layer, shape)
+	}
+
+	if cfg.Size <= 0 {
+		return nil, fmt.Errorf("bin size must be positive: layer = %s, size = %v", layer, cfg.Size)
+	}
+
+	aggs, err := newAggregates(layer, cfg.Aggregates, attrs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &binner{shape: shape, size: cfg.Size, aggregates: aggs}, nil
+}
+
+// attributes describes the attributes of the cells, replacing those of the points
+func (b *binner) attributes(attrs []Attribute) []Attribute {
+	out := []Attribute{{Name: binCount, Type: TypeInteger}}
+
+	for _, a := range b.aggregates {
+		out = append(out, a.attribute(attrs))
+	}
+
+	return out
+}
+
+// bin returns a polygon feature for each cell holding a point, leaving out the cells which are entirely outside of
+// the tile. Members of multi points are counted individually, while the aggregates take each feature once per cell.
+// Other geometries are ignored. The origin is the position of the tile in world pixels, see origin.
+func (b *binner) bin(features []*feature, o geom.Coordinate, extent uint32) []*feature {
+	var cells []cell
+	groups := map[cell]*group{}
+
+	add := func(c geom.Coordinate, f *feature) {
+		k := b.cell(geom.Coordinate{c[0] + o[0], c[1] + o[1]})
+
+		g, ok := groups[k]
+		if !ok {
+			g = &group{}
+			groups[k] = g
+			cells = append(cells, k)
+		}
+
+		g.count++
+
+		// the members of a multi point are added one after the other
+		if n := len(g.features); n == 0 || g.features[n-1] != f {
+			g.features = append(g.features, f)
+		}
+	}
+
+	for _, f := range features {
+		switch g := f.geom.(type) {
+		case *geom.Point:
+			add(g.Coordinate, f)
+		case *geom.MultiPoint:
+			for _, p := range g.Points {
+				add(p.Coordinate, f)
+			}
+		}
+	}
+
+	out := make([]*feature, 0, len(cells))
+
+	for _, k := range cells {
+		ring := b.ring(k)
+		for idx, c := range ring {
+			ring[idx] = geom.Coordinate{c[0] - o[0], c[1] - o[1]}
+		}
+
+		if !overlaps(ring, float64(extent)) {
+			continue
+		}
+
+		g := groups[k]
+		props := []property{{binCount, g.count}}
+
+		for _, a := range b.aggregates {
+			if v := a.apply(g.features); v != nil {
+				props = append(props, property{a.name, v})
+			}
+		}
+
+		out = append(out, &feature{
+			geom:  &geom.Polygon{Rings: []geom.LinearRing{{Coordinates: ring}}},
+			props: props,
+		})
+	}
+
+	return out
+}
+
+// cell finds the cell holding the (world pixel) coordinate: the column and row for squares or the axial coordinates of
+// flat topped hexagons
+func (b *binner) cell(c geom.Coordinate) cell {
+	if b.shape == BinSquare {
+		return cell{int(math.Floor(c[0] / b.size)), int(math.Floor(c[1] / b.size))}
+	}
+
+	r := b.size / 2
+	q := (2.0 / 3 * c[0]) / r
+	s := (-1.0/3*c[0] + math.Sqrt(3)/3*c[1]) / r
+
+	return hexRound(q, s)
+}
+
+// hexRound rounds fractional axial coordinates to the nearest hexagon (via cube coordinates)
+func hexRound(q, r float64) cell {
+	s := -q - r
+	rq, rr, rs := math.Round(q), math.Round(r), math.Round(s)
+	dq, dr, ds := math.Abs(rq-q), math.Abs(rr-r), math.Abs(rs-s)
+
+	switch {
+	case dq > dr && dq > ds:
+		rq = -rr - rs
+	case dr > ds:
+		rr = -rq - rs
+	}
+
+	return cell{int(rq), int(rr)}
+}
+
+// ring returns the closed outline of the cell in world pixels
+func (b *binner) ring(k cell) []geom.Coordinate {
+	if b.shape == BinSquare {
+		x, y := float64(k.x)*b.size, float64(k.y)*b.size
+		return []geom.Coordinate{{x, y}, {x + b.size, y}, {x + b.size, y + b.size}, {x, y + b.size}, {x, y}}
+	}
+
+	r := b.size / 2
+	cx := r * 3 / 2 * float64(k.x)
+	cy := r * math.Sqrt(3) * (float64(k.y) + float64(k.x)/2)
+
+	ring := make([]geom.Coordinate, 0, 7)
+	for corner := 0; corner < 6; corner++ {
+		a := math.Pi / 3 * float64(corner)
+		ring = append(ring, geom.Coordinate{cx + r*math.Cos(a), cy + r*math.Sin(a)})
+	}
+
+	return append(ring, ring[0])
+}
+
+// overlaps checks if the bounds of the ring overlap the tile
+func overlaps(ring []geom.Coordinate, extent float64) bool {
+	minx, miny, maxx, maxy := bounds(ring)
+	return maxx > 0 && minx < extent && maxy > 0 && miny < extent
+}
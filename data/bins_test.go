@@ -0,0 +1,130 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/devork/geom"
+	"github.com/devork/grava/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBinner(t *testing.T) {
+	b, err := newBinner("density", nil, places)
+	require.NoError(t, err)
+	require.Nil(t, b)
+
+	_, err = newBinner("density", &config.Bins{Shape: "triangle", Size: 10}, places)
+	require.Error(t, err)
+
+	_, err = newBinner("density", &config.Bins{Size: -1}, places)
+	require.Error(t, err)
+
+	_, err = newBinner("density", &config.Bins{Size: 10, Aggregates: []config.Aggregate{{Column: "area", Function: "sum"}}}, places)
+	require.Error(t, err)
+
+	b, err = newBinner("density", &config.Bins{Size: 10, Aggregates: []config.Aggregate{{Column: "pop", Function: "avg"}}}, places)
+	require.NoError(t, err)
+	require.Equal(t, BinSquare, b.shape)
+	require.Equal(t, []Attribute{{Name: binCount, Type: TypeInteger}, {Name: "pop_avg", Type: TypeFloat}}, b.attributes(nil))
+}
+
+func TestBinSquare(t *testing.T) {
+	b, err := newBinner("density", &config.Bins{Size: 10, Aggregates: []config.Aggregate{{Column: "pop", Function: "sum"}}}, places)
+	require.NoError(t, err)
+
+	cells := b.bin([]*feature{
+		point(1, 1, property{"pop", int64(1)}),
+		point(9, 9, property{"pop", int64(2)}),
+		// both points are counted, the population only once
+		&feature{
+			geom:  &geom.MultiPoint{Points: []geom.Point{{Coordinate: geom.Coordinate{15, 5}}, {Coordinate: geom.Coordinate{19, 1}}}},
+			props: []property{{"pop", int64(5)}},
+		},
+		&feature{geom: &geom.LineString{Coordinates: []geom.Coordinate{{0, 0}, {5, 5}}}},
+		// outside of the tile
+		point(-5, 5, property{"pop", int64(4)}),
+	}, geom.Coordinate{0, 0}, 100)
+
+	require.Len(t, cells, 2)
+
+	require.Equal(t, []geom.Coordinate{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}, cells[0].geom.(*geom.Polygon).Rings[0].Coordinates)
+	require.Equal(t, []property{{binCount, int64(2)}, {"pop_sum", int64(3)}}, cells[0].props)
+
+	require.Equal(t, []geom.Coordinate{{10, 0}, {20, 0}, {20, 10}, {10, 10}, {10, 0}}, cells[1].geom.(*geom.Polygon).Rings[0].Coordinates)
+	require.Equal(t, []property{{binCount, int64(2)}, {"pop_sum", int64(5)}}, cells[1].props)
+}
+
+func TestBinHexagon(t *testing.T) {
+	b, err := newBinner("density", &config.Bins{Shape: BinHexagon, Size: 20}, places)
+	require.NoError(t, err)
+
+	// centres of the hexagons next to each other
+	require.Equal(t, cell{0, 0}, b.cell(geom.Coordinate{0, 0}))
+	require.Equal(t, cell{1, 0}, b.cell(geom.Coordinate{15, 10 * math.Sqrt(3) / 2}))
+	require.Equal(t, cell{0, 1}, b.cell(geom.Coordinate{0, 10 * math.Sqrt(3)}))
+	require.Equal(t, cell{-1, 0}, b.cell(geom.Coordinate{-15, -10 * math.Sqrt(3) / 2}))
+
+	// every point is in the hexagon of its cell
+	for x := 0.0; x < 50; x += 3 {
+		for y := 0.0; y < 50; y += 3 {
+			ring := b.ring(b.cell(geom.Coordinate{x, y}))
+			cx, cy := (ring[0][0]+ring[3][0])/2, (ring[0][1]+ring[3][1])/2
+			require.True(t, math.Hypot(x-cx, y-cy) <= 10.0001, "%v, %v", x, y)
+		}
+	}
+
+	cells := b.bin([]*feature{point(1, 1), point(2, 2), point(40, 40)}, geom.Coordinate{0, 0}, 100)
+	require.Len(t, cells, 2)
+	require.Len(t, cells[0].geom.(*geom.Polygon).Rings[0].Coordinates, 7)
+	require.Equal(t, []property{{binCount, int64(2)}}, cells[0].props)
+}
+
+func TestBinTiles(t *testing.T) {
+	// points in world pixels either side of the edge between two tiles of extent 100
+	var world []geom.Coordinate
+	for x := 60.0; x < 140; x += 7 {
+		for y := 3.0; y < 100; y += 11 {
+			world = append(world, geom.Coordinate{x, y})
+		}
+	}
+
+	for _, cfg := range []*config.Bins{{Shape: BinSquare, Size: 30}, {Shape: BinHexagon, Size: 30}} {
+		b, err := newBinner("density", cfg, places)
+		require.NoError(t, err)
+
+		// bins the points read by the tile at the origin, a cell past the tile, by the cell outline in world pixels
+		tile := func(o geom.Coordinate) map[string]*feature {
+			read := newClipper(100, 30)
+
+			var features []*feature
+			for _, w := range world {
+				if f := point(w[0]-o[0], w[1]-o[1]); read.clip(f.geom) != nil {
+					features = append(features, f)
+				}
+			}
+
+			cells := map[string]*feature{}
+			for _, f := range b.bin(features, o, 100) {
+				var ring []geom.Coordinate
+				for _, c := range f.geom.(*geom.Polygon).Rings[0].Coordinates {
+					ring = append(ring, geom.Coordinate{math.Round(c[0] + o[0]), math.Round(c[1] + o[1])})
+				}
+				cells[fmt.Sprint(ring)] = f
+			}
+			return cells
+		}
+
+		left, right := tile(geom.Coordinate{0, 0}), tile(geom.Coordinate{100, 0})
+
+		shared := 0
+		for k, f := range left {
+			if g, ok := right[k]; ok {
+				shared++
+				require.Equal(t, f.props, g.props, "shape = %s, cell = %s", cfg.Shape, k)
+			}
+		}
+		require.True(t, shared > 0, "shape = %s", cfg.Shape)
+	}
+}
@@ -0,0 +1,280 @@
+package data
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/devork/geom"
+	"github.com/devork/grava/geo"
+	"github.com/golang/protobuf/proto"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Degradation steps, taken in order while a tile is over the size budget of its source
+const (
+	DegradeSimplify   = "simplify"
+	DegradeAttributes = "attributes"
+	DegradeFeatures   = "features"
+	DegradeLayers     = "layers"
+)
+
+var (
+	// simplification tolerance of the simplify step, as a fraction of the layer extent (a pixel of a 256 pixel tile)
+	degradeTolerance = 1.0 / 256
+
+	// fraction of the remaining lines and polygons dropped by each round of the features step, and the number of rounds
+	degradeFraction = 0.25
+	degradeRounds   = 4
+)
+
+// degrade reworks the contents of a tile until it encodes within max bytes: lines and polygons are simplified, low
+// priority attributes dropped, then the smallest lines and polygons and finally the optional layers (the last layer of
+// the source first). Steps stop as soon as the tile fits. The encoded tile is returned with the steps taken; the tile
+// may still be over the budget if all the steps have been taken.
+func degrade(contents []*content, data []byte, max int) ([]byte, []string, error) {
+	var steps []string
+
+	// apply runs a step (if it changes the contents) and encodes the tile again
+	apply := func(step string, fn func() bool) error {
+		if len(data) <= max || !fn() {
+			return nil
+		}
+
+		if len(steps) == 0 || steps[len(steps)-1] != step {
+			steps = append(steps, step)
+		}
+
+		var err error
+		data, err = proto.Marshal(encode(contents))
+		return err
+	}
+
+	if err := apply(DegradeSimplify, func() bool { return simplifyContents(contents) }); err != nil {
+		return nil, nil, err
+	}
+
+	if err := apply(DegradeAttributes, func() bool { return dropAttributes(contents) }); err != nil {
+		return nil, nil, err
+	}
+
+	for round := 0; round < degradeRounds; round++ {
+		if err := apply(DegradeFeatures, func() bool { return dropSmallest(contents, degradeFraction) }); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for idx := len(contents) - 1; idx >= 0; idx-- {
+		lyr := contents[idx].lyr
+		if err := apply(DegradeLayers, func() bool { return dropLayer(contents, lyr) }); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return data, steps, nil
+}
+
+// simplifyContents simplifies the lines and polygons of every layer, dropping those which collapse. It reports a
+// change only if vertices were removed, which they aren't when the layer is already generalized more coarsely.
+//
+// Unlike the generalization of a layer, this runs on the geometries clipped to the tile (the unclipped geometries are
+// long gone), so a feature crossing into a neighbouring tile which fits its budget may not line up across the edge.
+func simplifyContents(contents []*content) bool {
+	changed := false
+
+	for _, c := range contents {
+		gz := &generalizer{tolerance: float64(c.lyr.extent) * degradeTolerance}
+
+		features := c.features[:0]
+		for _, f := range c.features {
+			switch f.geom.(type) {
+			case *geom.Point, *geom.MultiPoint:
+				features = append(features, f)
+				continue
+			}
+
+			g := gz.generalize(f.geom)
+			if g == nil {
+				changed = true
+				continue
+			}
+
+			if vertices(g) < vertices(f.geom) {
+				changed = true
+				f = &feature{geom: g, id: f.id, props: f.props}
+			}
+
+			features = append(features, f)
+		}
+
+		c.features = features
+	}
+
+	return changed
+}
+
+// vertices counts the vertices of a line or polygon
+func vertices(g geom.Geometry) int {
+	n := 0
+
+	switch g := g.(type) {
+	case *geom.LineString:
+		n = len(g.Coordinates)
+	case *geom.MultiLineString:
+		for _, ls := range g.LineStrings {
+			n += len(ls.Coordinates)
+		}
+	case *geom.Polygon:
+		for _, ring := range g.Rings {
+			n += len(ring.Coordinates)
+		}
+	case *geom.MultiPolygon:
+		for _, p := range g.Polygons {
+			for _, ring := range p.Rings {
+				n += len(ring.Coordinates)
+			}
+		}
+	}
+
+	return n
+}
+
+// dropAttributes removes the low priority attributes of every layer
+func dropAttributes(contents []*content) bool {
+	changed := false
+
+	for _, c := range contents {
+		if len(c.lyr.lowPriority) == 0 {
+			continue
+		}
+
+		for idx, f := range c.features {
+			props := make([]property, 0, len(f.props))
+			for _, p := range f.props {
+				if !c.lyr.lowPriority[p.key] {
+					props = append(props, p)
+				}
+			}
+
+			if len(props) < len(f.props) {
+				changed = true
+				c.features[idx] = &feature{geom: f.geom, id: f.id, props: props}
+			}
+		}
+	}
+
+	return changed
+}
+
+// dropSmallest removes the given fraction of the lines and polygons (at least one) across all layers, smallest first.
+// Features are compared by size relative to the extent of their layer. Points have no size to compare, so are kept (a
+// layer of points is reduced by clustering instead, or left out as an optional layer).
+func dropSmallest(contents []*content, fraction float64) bool {
+	type candidate struct {
+		f    *feature
+		size float64
+	}
+
+	var candidates []candidate
+	for _, c := range contents {
+		extent := float64(c.lyr.extent)
+
+		for _, f := range c.features {
+			if s, ok := size(f.geom); ok {
+				candidates = append(candidates, candidate{f, s / extent})
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return false
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].size < candidates[j].size })
+
+	n := int(math.Ceil(float64(len(candidates)) * fraction))
+	drop := map[*feature]bool{}
+	for _, cd := range candidates[:n] {
+		drop[cd.f] = true
+	}
+
+	for _, c := range contents {
+		features := c.features[:0]
+		for _, f := range c.features {
+			if !drop[f] {
+				features = append(features, f)
+			}
+		}
+		c.features = features
+	}
+
+	return true
+}
+
+// size measures a line (by length) or polygon (by the square root of its area, so it compares with lengths)
+func size(g geom.Geometry) (float64, bool) {
+	switch g := g.(type) {
+	case *geom.LineString:
+		return length(g.Coordinates), true
+	case *geom.MultiLineString:
+		var sum float64
+		for _, ls := range g.LineStrings {
+			sum += length(ls.Coordinates)
+		}
+		return sum, true
+	case *geom.Polygon:
+		return math.Sqrt(polygonArea(g)), true
+	case *geom.MultiPolygon:
+		var sum float64
+		for idx := range g.Polygons {
+			sum += polygonArea(&g.Polygons[idx])
+		}
+		return math.Sqrt(sum), true
+	}
+
+	return 0, false
+}
+
+// polygonArea returns the area of the polygon less its holes
+func polygonArea(p *geom.Polygon) float64 {
+	var sum float64
+	for idx, ring := range p.Rings {
+		a := math.Abs(area(ring.Coordinates)) / 2
+		if idx > 0 {
+			a = -a
+		}
+		sum += a
+	}
+	return math.Max(sum, 0)
+}
+
+// dropLayer leaves the contents read from the layer (itself and its label layer) out of the tile if the layer is
+// optional
+func dropLayer(contents []*content, lyr *Layer) bool {
+	if !lyr.optional {
+		return false
+	}
+
+	changed := false
+	for _, c := range contents {
+		if c.lyr == lyr && !c.dropped {
+			c.dropped = true
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// logDegraded reports a tile over its size budget and the steps taken to reduce it
+func logDegraded(box *geo.BBox, name string, size, reduced, max int, steps []string) {
+	if reduced > max {
+		log.Warnf("tile over size budget: bbox = %s, source = %s, size = %d, reduced = %d, max = %d, steps = %s",
+			box.GoString(), name, size, reduced, max, strings.Join(steps, ","))
+		return
+	}
+
+	log.Infof("tile degraded to fit size budget: bbox = %s, source = %s, size = %d, reduced = %d, max = %d, steps = %s",
+		box.GoString(), name, size, reduced, max, strings.Join(steps, ","))
+}
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/devork/geom"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDegradeSteps(t *testing.T) {
+	road := &Layer{Name: "road", extent: 4096, lowPriority: map[string]bool{"name": true}}
+	place := &Layer{Name: "place", extent: 4096, optional: true}
+
+	contents := func() []*content {
+		var roads []*feature
+		for y := 0.0; y < 4096; y += 64 {
+			roads = append(roads, wiggle(y, 4000, property{"class", "A Road"}, property{"name", "road " + string(rune('a'+int(y/64)%26))}))
+		}
+
+		var places []*feature
+		for x := 0.0; x < 4096; x += 16 {
+			places = append(places, point(x, x, property{"name", "place"}))
+		}
+
+		return []*content{{lyr: road, name: "road", features: roads}, {lyr: place, name: "place", features: places}}
+	}
+
+	full, err := proto.Marshal(encode(contents()))
+	require.NoError(t, err)
+
+	// simplifying the wiggles is enough
+	data, steps, err := degrade(contents(), full, len(full)/2)
+	require.NoError(t, err)
+	require.Equal(t, []string{DegradeSimplify}, steps)
+	require.True(t, len(data) <= len(full)/2)
+
+	// nothing can fit, so every step is taken
+	cs := contents()
+	data, steps, err = degrade(cs, full, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{DegradeSimplify, DegradeAttributes, DegradeFeatures, DegradeLayers}, steps)
+	require.True(t, len(data) > 10)
+
+	require.Nil(t, cs[0].features[0].prop("name"))
+	require.True(t, len(cs[0].features) < 64)
+	require.False(t, cs[0].dropped)
+	require.True(t, cs[1].dropped)
+
+	tile := encode(cs)
+	require.Len(t, tile.Layers, 1)
+	require.Equal(t, "road", tile.Layers[0].GetName())
+}
+
+func TestSimplifyContents(t *testing.T) {
+	lyr := &Layer{extent: 4096}
+	straight := line([]geom.Coordinate{{0, 0}, {100, 0}})
+
+	// nothing to simplify
+	c := &content{lyr: lyr, features: []*feature{straight, point(5, 5)}}
+	require.False(t, simplifyContents([]*content{c}))
+	require.Equal(t, []*feature{straight, point(5, 5)}, c.features)
+
+	c.features = append(c.features, wiggle(10, 100))
+	require.True(t, simplifyContents([]*content{c}))
+	require.Equal(t, 2, vertices(c.features[2].geom))
+}
+
+func TestDropSmallest(t *testing.T) {
+	lyr := &Layer{extent: 100}
+	short := line([]geom.Coordinate{{0, 0}, {1, 0}})
+	long := line([]geom.Coordinate{{0, 0}, {50, 0}})
+	big := &feature{geom: polygon([]geom.Coordinate{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}})}
+	p := point(0, 0)
+
+	c := &content{lyr: lyr, features: []*feature{long, p, short, big}}
+
+	require.True(t, dropSmallest([]*content{c}, 0.25))
+	require.Equal(t, []*feature{long, p, big}, c.features)
+
+	require.True(t, dropSmallest([]*content{c}, 0.25))
+	require.Equal(t, []*feature{long, p}, c.features)
+
+	require.True(t, dropSmallest([]*content{c}, 0.25))
+	require.False(t, dropSmallest([]*content{c}, 0.25))
+	require.Equal(t, []*feature{p}, c.features)
+}
+
+func TestDropLayer(t *testing.T) {
+	lyr := &Layer{Name: "building"}
+	contents := []*content{{lyr: lyr, name: "building"}, {lyr: lyr, name: "building_label"}}
+
+	require.False(t, dropLayer(contents, lyr))
+
+	lyr.optional = true
+	require.True(t, dropLayer(contents, lyr))
+	require.True(t, contents[0].dropped)
+	require.True(t, contents[1].dropped)
+	require.False(t, dropLayer(contents, lyr))
+}
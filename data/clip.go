@@ -0,0 +1,398 @@
+package data
+
+import (
+	"math"
+
+	"github.com/devork/geom"
+	"github.com/devork/grava/geo"
+)
+
+// project converts a geometry from ground units into integer tile coordinates. The returned geometry is a copy,
+// the source geometry is left untouched.
+func project(g geom.Geometry, box *geo.BBox, width, height float64) geom.Geometry {
+	tx := func(c geom.Coordinate) geom.Coordinate {
+		return geom.Coordinate{
+			math.Floor((c[0] - box.Minx) * width),
+			math.Floor((c[1] - box.Miny) * height),
+		}
+	}
+
+	txs := func(cs []geom.Coordinate) []geom.Coordinate {
+		out := make([]geom.Coordinate, len(cs))
+		for idx, c := range cs {
+			out[idx] = tx(c)
+		}
+		return out
+	}
+
+	txp := func(p *geom.Polygon) geom.Polygon {
+		out := geom.Polygon{Hdr: p.Hdr, Rings: make([]geom.LinearRing, len(p.Rings))}
+		for idx, ring := range p.Rings {
+			out.Rings[idx] = geom.LinearRing{Coordinates: txs(ring.Coordinates)}
+		}
+		return out
+	}
+
+	switch g := g.(type) {
+	case *geom.Point:
+		return &geom.Point{Hdr: g.Hdr, Coordinate: tx(g.Coordinate)}
+	case *geom.MultiPoint:
+		out := &geom.MultiPoint{Hdr: g.Hdr, Points: make([]geom.Point, len(g.Points))}
+		for idx, p := range g.Points {
+			out.Points[idx] = geom.Point{Hdr: p.Hdr, Coordinate: tx(p.Coordinate)}
+		}
+		return out
+	case *geom.LineString:
+		return &geom.LineString{Hdr: g.Hdr, Coordinates: txs(g.Coordinates)}
+	case *geom.MultiLineString:
+		out := &geom.MultiLineString{Hdr: g.Hdr, LineStrings: make([]geom.LineString, len(g.LineStrings))}
+		for idx, ls := range g.LineStrings {
+			out.LineStrings[idx] = geom.LineString{Hdr: ls.Hdr, Coordinates: txs(ls.Coordinates)}
+		}
+		return out
+	case *geom.Polygon:
+		p := txp(g)
+		return &p
+	case *geom.MultiPolygon:
+		out := &geom.MultiPolygon{Hdr: g.Hdr, Polygons: make([]geom.Polygon, len(g.Polygons))}
+		for idx := range g.Polygons {
+			out.Polygons[idx] = txp(&g.Polygons[idx])
+		}
+		return out
+	}
+
+	return g
+}
+
+// clipper clips tile space geometries against the square [min, max] on both axes. This is normally the tile extent
+// grown by the layer buffer so that lines and labels are not cut hard at the tile edge.
+type clipper struct {
+	min, max float64
+}
+
+// newClipper creates a clipper for a tile of the given extent with buffer pixels either side
+func newClipper(extent, buffer uint32) *clipper {
+	return &clipper{min: -float64(buffer), max: float64(extent) + float64(buffer)}
+}
+
+// clip returns the part of g within the clip region. A nil geometry is returned when nothing remains (or what
+// remains has collapsed to a degenerate shape). Multi geometries with a single remaining member are returned as
+// their single counterpart.
+func (c *clipper) clip(g geom.Geometry) geom.Geometry {
+	switch g := g.(type) {
+	case *geom.Point:
+		if !c.contains(g.Coordinate) {
+			return nil
+		}
+		return g
+
+	case *geom.MultiPoint:
+		points := []geom.Point{}
+		for _, p := range g.Points {
+			if c.contains(p.Coordinate) {
+				points = append(points, p)
+			}
+		}
+
+		switch len(points) {
+		case 0:
+			return nil
+		case 1:
+			return &geom.Point{Hdr: g.Hdr, Coordinate: points[0].Coordinate}
+		}
+		return &geom.MultiPoint{Hdr: g.Hdr, Points: points}
+
+	case *geom.LineString:
+		return c.lines(g.Hdr, c.clipLine(g.Coordinates))
+
+	case *geom.MultiLineString:
+		var lines [][]geom.Coordinate
+		for _, ls := range g.LineStrings {
+			lines = append(lines, c.clipLine(ls.Coordinates)...)
+		}
+		return c.lines(g.Hdr, lines)
+
+	case *geom.Polygon:
+		p, ok := c.clipPolygon(g)
+		if !ok {
+			return nil
+		}
+		return p
+
+	case *geom.MultiPolygon:
+		polygons := []geom.Polygon{}
+		for idx := range g.Polygons {
+			if p, ok := c.clipPolygon(&g.Polygons[idx]); ok {
+				polygons = append(polygons, *p)
+			}
+		}
+
+		switch len(polygons) {
+		case 0:
+			return nil
+		case 1:
+			return &polygons[0]
+		}
+		return &geom.MultiPolygon{Hdr: g.Hdr, Polygons: polygons}
+	}
+
+	return g
+}
+
+func (c *clipper) contains(p geom.Coordinate) bool {
+	return p[0] >= c.min && p[0] <= c.max && p[1] >= c.min && p[1] <= c.max
+}
+
+// within checks if every coordinate is inside the clip region, allowing the clip work to be skipped
+func (c *clipper) within(cs []geom.Coordinate) bool {
+	for _, p := range cs {
+		if !c.contains(p) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *clipper) lines(hdr geom.Hdr, lines [][]geom.Coordinate) geom.Geometry {
+	switch len(lines) {
+	case 0:
+		return nil
+	case 1:
+		return &geom.LineString{Hdr: hdr, Coordinates: lines[0]}
+	}
+
+	mls := &geom.MultiLineString{Hdr: hdr, LineStrings: make([]geom.LineString, len(lines))}
+	for idx, line := range lines {
+		mls.LineStrings[idx] = geom.LineString{Hdr: hdr, Coordinates: line}
+	}
+	return mls
+}
+
+// clipLine clips each segment of the line with Liang-Barsky, splitting the line wherever it leaves the clip region.
+// Parts that collapse to a single point are dropped.
+func (c *clipper) clipLine(cs []geom.Coordinate) [][]geom.Coordinate {
+	if c.within(cs) {
+		if line := dedupe(cs); len(line) > 1 {
+			return [][]geom.Coordinate{line}
+		}
+		return nil
+	}
+
+	lines := [][]geom.Coordinate{}
+	var current []geom.Coordinate
+
+	flush := func() {
+		if line := dedupe(current); len(line) > 1 {
+			lines = append(lines, line)
+		}
+		current = nil
+	}
+
+	for idx := 1; idx < len(cs); idx++ {
+		a, b, ok := c.clipSegment(cs[idx-1], cs[idx])
+
+		if !ok {
+			flush()
+			continue
+		}
+
+		if len(current) == 0 || !equal(current[len(current)-1], a) {
+			flush()
+			current = append(current, a)
+		}
+
+		current = append(current, b)
+
+		// segment left the clip region - anything following starts a new part
+		if !equal(b, cs[idx]) {
+			flush()
+		}
+	}
+
+	flush()
+
+	return lines
+}
+
+// clipSegment returns the part of the segment a -> b within the clip region
+func (c *clipper) clipSegment(a, b geom.Coordinate) (geom.Coordinate, geom.Coordinate, bool) {
+	dx := b[0] - a[0]
+	dy := b[1] - a[1]
+
+	t0, t1 := 0.0, 1.0
+
+	edges := [4][2]float64{
+		{-dx, a[0] - c.min},
+		{dx, c.max - a[0]},
+		{-dy, a[1] - c.min},
+		{dy, c.max - a[1]},
+	}
+
+	for _, e := range edges {
+		p, q := e[0], e[1]
+
+		if p == 0 {
+			if q < 0 {
+				return nil, nil, false
+			}
+			continue
+		}
+
+		r := q / p
+		if p < 0 {
+			if r > t1 {
+				return nil, nil, false
+			}
+			if r > t0 {
+				t0 = r
+			}
+		} else {
+			if r < t0 {
+				return nil, nil, false
+			}
+			if r < t1 {
+				t1 = r
+			}
+		}
+	}
+
+	ca, cb := a, b
+
+	if t0 > 0 {
+		ca = geom.Coordinate{round(a[0] + t0*dx), round(a[1] + t0*dy)}
+	}
+
+	if t1 < 1 {
+		cb = geom.Coordinate{round(a[0] + t1*dx), round(a[1] + t1*dy)}
+	}
+
+	return ca, cb, true
+}
+
+// clipPolygon clips each ring separately. If the exterior ring is lost, so is the polygon; holes which disappear
+// are simply dropped.
+func (c *clipper) clipPolygon(p *geom.Polygon) (*geom.Polygon, bool) {
+	if len(p.Rings) == 0 {
+		return nil, false
+	}
+
+	out := &geom.Polygon{Hdr: p.Hdr}
+
+	for idx, ring := range p.Rings {
+		cs := c.clipRing(ring.Coordinates)
+
+		if cs == nil {
+			if idx == 0 {
+				return nil, false
+			}
+			continue
+		}
+
+		out.Rings = append(out.Rings, geom.LinearRing{Coordinates: cs})
+	}
+
+	return out, true
+}
+
+// clipRing runs Sutherland-Hodgman over the ring against each edge of the clip region. The result is closed and
+// deduplicated, or nil if it no longer encloses any area.
+func (c *clipper) clipRing(cs []geom.Coordinate) []geom.Coordinate {
+	if len(cs) < 4 {
+		return nil
+	}
+
+	if !c.within(cs) {
+		// work with an open ring
+		ring := cs[:len(cs)-1]
+
+		ring = c.clipEdge(ring, func(p geom.Coordinate) bool { return p[0] >= c.min }, func(a, b geom.Coordinate) geom.Coordinate {
+			return intersectX(a, b, c.min)
+		})
+		ring = c.clipEdge(ring, func(p geom.Coordinate) bool { return p[0] <= c.max }, func(a, b geom.Coordinate) geom.Coordinate {
+			return intersectX(a, b, c.max)
+		})
+		ring = c.clipEdge(ring, func(p geom.Coordinate) bool { return p[1] >= c.min }, func(a, b geom.Coordinate) geom.Coordinate {
+			return intersectY(a, b, c.min)
+		})
+		ring = c.clipEdge(ring, func(p geom.Coordinate) bool { return p[1] <= c.max }, func(a, b geom.Coordinate) geom.Coordinate {
+			return intersectY(a, b, c.max)
+		})
+
+		if len(ring) == 0 {
+			return nil
+		}
+
+		cs = append(ring, ring[0])
+	}
+
+	cs = dedupe(cs)
+
+	// 3 distinct vertices plus the closing vertex
+	if len(cs) < 4 || area(cs) == 0 {
+		return nil
+	}
+
+	return cs
+}
+
+func (c *clipper) clipEdge(ring []geom.Coordinate, inside func(geom.Coordinate) bool, intersect func(a, b geom.Coordinate) geom.Coordinate) []geom.Coordinate {
+	if len(ring) == 0 {
+		return ring
+	}
+
+	out := make([]geom.Coordinate, 0, len(ring)+4)
+	prev := ring[len(ring)-1]
+
+	for _, cur := range ring {
+		if inside(cur) {
+			if !inside(prev) {
+				out = append(out, intersect(prev, cur))
+			}
+			out = append(out, cur)
+		} else if inside(prev) {
+			out = append(out, intersect(prev, cur))
+		}
+		prev = cur
+	}
+
+	return out
+}
+
+func intersectX(a, b geom.Coordinate, x float64) geom.Coordinate {
+	t := (x - a[0]) / (b[0] - a[0])
+	return geom.Coordinate{x, round(a[1] + t*(b[1]-a[1]))}
+}
+
+func intersectY(a, b geom.Coordinate, y float64) geom.Coordinate {
+	t := (y - a[1]) / (b[1] - a[1])
+	return geom.Coordinate{round(a[0] + t*(b[0]-a[0])), y}
+}
+
+// dedupe removes consecutive repeated coordinates
+func dedupe(cs []geom.Coordinate) []geom.Coordinate {
+	out := make([]geom.Coordinate, 0, len(cs))
+	for _, p := range cs {
+		if len(out) > 0 && equal(out[len(out)-1], p) {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// area returns twice the signed area of the closed ring
+func area(cs []geom.Coordinate) float64 {
+	var sum float64
+	for idx := 1; idx < len(cs); idx++ {
+		sum += cs[idx-1][0]*cs[idx][1] - cs[idx][0]*cs[idx-1][1]
+	}
+	return sum
+}
+
+func equal(a, b geom.Coordinate) bool {
+	return a[0] == b[0] && a[1] == b[1]
+}
+
+func round(v float64) float64 {
+	return math.Floor(v + 0.5)
+}
@@ -0,0 +1,74 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/devork/geom"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClipLine(t *testing.T) {
+	c := &clipper{min: 0, max: 10}
+
+	line := &geom.LineString{
+		Coordinates: []geom.Coordinate{
+			{-5, 5},
+			{5, 5},
+			{5, 15},
+			{8, 15},
+			{8, 5},
+		},
+	}
+
+	g := c.clip(line)
+	require.NotNil(t, g)
+
+	mls, ok := g.(*geom.MultiLineString)
+	require.True(t, ok, "expected line to be split in two")
+	require.Equal(t, 2, len(mls.LineStrings))
+	require.Equal(t, []geom.Coordinate{{0, 5}, {5, 5}, {5, 10}}, mls.LineStrings[0].Coordinates)
+	require.Equal(t, []geom.Coordinate{{8, 10}, {8, 5}}, mls.LineStrings[1].Coordinates)
+}
+
+func TestClipLineOutside(t *testing.T) {
+	c := &clipper{min: 0, max: 10}
+
+	line := &geom.LineString{
+		Coordinates: []geom.Coordinate{{-5, -5}, {-5, 20}},
+	}
+
+	require.Nil(t, c.clip(line))
+}
+
+func TestClipPolygonWithHole(t *testing.T) {
+	c := &clipper{min: 0, max: 10}
+
+	polygon := &geom.Polygon{
+		Rings: []geom.LinearRing{
+			{Coordinates: []geom.Coordinate{{-10, -10}, {20, -10}, {20, 20}, {-10, 20}, {-10, -10}}},
+			{Coordinates: []geom.Coordinate{{2, 2}, {2, 4}, {4, 4}, {4, 2}, {2, 2}}},
+			{Coordinates: []geom.Coordinate{{12, 12}, {12, 14}, {14, 14}, {14, 12}, {12, 12}}},
+		},
+	}
+
+	g := c.clip(polygon)
+	require.NotNil(t, g)
+
+	p := g.(*geom.Polygon)
+	require.Equal(t, 2, len(p.Rings), "expected hole outside of the clip region to be dropped")
+	require.Equal(t, float64(200), area(p.Rings[0].Coordinates))
+	require.Equal(t, polygon.Rings[1].Coordinates, p.Rings[1].Coordinates)
+}
+
+func TestClipPolygonDegenerate(t *testing.T) {
+	c := &clipper{min: 0, max: 10}
+
+	// only touches the clip region along an edge
+	polygon := &geom.Polygon{
+		Rings: []geom.LinearRing{
+			{Coordinates: []geom.Coordinate{{10, 0}, {20, 0}, {20, 10}, {10, 10}, {10, 0}}},
+		},
+	}
+
+	require.Nil(t, c.clip(polygon))
+}
@@ -0,0 +1,264 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/devork/geom"
+	"github.com/devork/grava/config"
+)
+
+// Cluster modes, see config.Cluster
+const (
+	ClusterGrid     = "grid"
+	ClusterDistance = "distance"
+)
+
+// pointCount is the attribute holding the number of points in a cluster
+const pointCount = "point_count"
+
+// clusterer groups the points of a layer in tile space. Features which aren't single points are left as they are.
+//
+// Grid cells are laid over the world rather than the tile, so neighbouring tiles agree on them, and the layer is read a
+// cell past its buffer (see Layer.margin) so a tile sees every point of the cells it overlaps. A group of points is only
+// served by the tile holding its centre, a group straddling a tile edge not being served twice.
+type clusterer struct {
+	maxZoom    *int
+	mode       string
+	radius     float64
+	priority   string
+	aggregates []aggregate
+}
+
+// newClusterer validates the cluster configuration of a layer against its attributes, returning nil if the layer isn't
+// clustered
+func newClusterer(layer string, cfg *config.Cluster, attrs []Attribute) (*clusterer, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	mode := cfg.Mode
+	if mode == "" {
+		mode = ClusterGrid
+	}
+
+	if mode != ClusterGrid && mode != ClusterDistance {
+		return nil, fmt.Errorf("unknown cluster mode: layer = %s, mode = %s", layer, mode)
+	}
+
+	if cfg.Radius <= 0 {
+		return nil, fmt.Errorf("cluster radius must be positive: layer = %s, radius = %v", layer, cfg.Radius)
+	}
+
+	if cfg.Priority != "" && !hasAttribute(attrs, cfg.Priority) {
+		return nil, fmt.Errorf("cluster priority attribute not found: layer = %s, name = %s", layer, cfg.Priority)
+	}
+
+	aggs, err := newAggregates(layer, cfg.Aggregates, attrs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &clusterer{
+		maxZoom:    cfg.MaxZoom,
+		mode:       mode,
+		radius:     cfg.Radius,
+		priority:   cfg.Priority,
+		aggregates: aggs,
+	}, nil
+}
+
+// applies checks if points are clustered at the given zoom
+func (c *clusterer) applies(zoom int) bool {
+	return c != nil && (c.maxZoom == nil || zoom <= *c.maxZoom)
+}
+
+// attributes describes the attributes of the clusters
+func (c *clusterer) attributes(attrs []Attribute) []Attribute {
+	out := []Attribute{{Name: pointCount, Type: TypeInteger, MaxZoom: c.maxZoom}}
+
+	for _, a := range c.aggregates {
+		attr := a.attribute(attrs)
+		attr.MaxZoom = c.maxZoom
+		out = append(out, attr)
+	}
+
+	return out
+}
+
+// cluster replaces the groups of points with a single point per group, leaving out the groups centred outside of the
+// tile. A point on its own is kept as it is. The origin is the position of the tile in world pixels, see origin.
+func (c *clusterer) cluster(features []*feature, o geom.Coordinate, extent uint32) []*feature {
+	var points, out []*feature
+
+	for _, f := range features {
+		if _, ok := f.geom.(*geom.Point); ok {
+			points = append(points, f)
+		} else {
+			out = append(out, f)
+		}
+	}
+
+	// highest priority first, so the groups (and first values) favour the most important points. Ties are broken by
+	// position, so neighbouring tiles take the points they share in the same order whatever order they were read in.
+	sort.SliceStable(points, func(i, j int) bool {
+		if c.priority != "" {
+			if ri, rj := c.rank(points[i]), c.rank(points[j]); ri != rj {
+				return ri > rj
+			}
+		}
+
+		p, q := points[i].geom.(*geom.Point).Coordinate, points[j].geom.(*geom.Point).Coordinate
+		if p[1] != q[1] {
+			return p[1] < q[1]
+		}
+		return p[0] < q[0]
+	})
+
+	var groups [][]*feature
+	if c.mode == ClusterDistance {
+		groups = c.byDistance(points, o)
+	} else {
+		groups = c.byGrid(points, o)
+	}
+
+	for _, group := range groups {
+		f := c.merge(group)
+
+		if len(group) > 1 {
+			p := f.geom.(*geom.Point).Coordinate
+			if p[0] < 0 || p[1] < 0 || p[0] >= float64(extent) || p[1] >= float64(extent) {
+				continue
+			}
+		}
+
+		out = append(out, f)
+	}
+
+	return out
+}
+
+// rank is the priority of a feature, features without one being ranked last
+func (c *clusterer) rank(f *feature) float64 {
+	if n, ok := number(f.prop(c.priority)); ok {
+		return n
+	}
+	return math.Inf(-1)
+}
+
+// cell is a cell of a grid laid over the world, e.g. of the cluster radius
+type cell struct {
+	x, y int
+}
+
+// cell finds the grid cell holding the point, the origin being the position of the tile in world pixels
+func (c *clusterer) cell(f *feature, o geom.Coordinate) cell {
+	p := f.geom.(*geom.Point).Coordinate
+	return cell{int(math.Floor((p[0] + o[0]) / c.radius)), int(math.Floor((p[1] + o[1]) / c.radius))}
+}
+
+// byGrid groups the points by grid cell
+func (c *clusterer) byGrid(points []*feature, o geom.Coordinate) [][]*feature {
+	var groups [][]*feature
+	index := map[cell]int{}
+
+	for _, f := range points {
+		k := c.cell(f, o)
+		idx, ok := index[k]
+
+		if !ok {
+			idx = len(groups)
+			index[k] = idx
+			groups = append(groups, nil)
+		}
+
+		groups[idx] = append(groups[idx], f)
+	}
+
+	return groups
+}
+
+// byDistance groups each point (in order of priority) with the points within the radius not already in a group
+func (c *clusterer) byDistance(points []*feature, o geom.Coordinate) [][]*feature {
+	var groups [][]*feature
+
+	// index of the points by cell, only the neighbouring cells need to be searched
+	index := map[cell][]int{}
+	for idx, f := range points {
+		k := c.cell(f, o)
+		index[k] = append(index[k], idx)
+	}
+
+	used := make([]bool, len(points))
+	r2 := c.radius * c.radius
+
+	for idx, f := range points {
+		if used[idx] {
+			continue
+		}
+
+		used[idx] = true
+		group := []*feature{f}
+
+		p := f.geom.(*geom.Point).Coordinate
+		k := c.cell(f, o)
+
+		var near []int
+		for x := k.x - 1; x <= k.x+1; x++ {
+			for y := k.y - 1; y <= k.y+1; y++ {
+				near = append(near, index[cell{x, y}]...)
+			}
+		}
+
+		// keep the priority order within the group
+		sort.Ints(near)
+
+		for _, n := range near {
+			if used[n] {
+				continue
+			}
+
+			q := points[n].geom.(*geom.Point).Coordinate
+			dx, dy := q[0]-p[0], q[1]-p[1]
+
+			if dx*dx+dy*dy <= r2 {
+				used[n] = true
+				group = append(group, points[n])
+			}
+		}
+
+		groups = append(groups, group)
+	}
+
+	return groups
+}
+
+// merge returns the single point of a group: at the centre of the group, with the size and aggregates of the group
+func (c *clusterer) merge(group []*feature) *feature {
+	if len(group) == 1 {
+		return group[0]
+	}
+
+	var x, y float64
+	for _, f := range group {
+		p := f.geom.(*geom.Point).Coordinate
+		x += p[0]
+		y += p[1]
+	}
+
+	n := float64(len(group))
+	props := []property{{pointCount, int64(len(group))}}
+
+	for _, a := range c.aggregates {
+		if v := a.apply(group); v != nil {
+			props = append(props, property{a.name, v})
+		}
+	}
+
+	return &feature{
+		geom:  &geom.Point{Hdr: group[0].geom.(*geom.Point).Hdr, Coordinate: geom.Coordinate{x / n, y / n}},
+		props: props,
+	}
+}
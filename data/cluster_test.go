@@ -0,0 +1,158 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/devork/geom"
+	"github.com/devork/grava/config"
+	"github.com/stretchr/testify/require"
+)
+
+// places are the attributes of the clustered points in the tests
+var places = []Attribute{{Name: "pop", Type: TypeInteger}, {Name: "rank", Type: TypeInteger}, {Name: "name", Type: TypeString}}
+
+func TestNewClusterer(t *testing.T) {
+	c, err := newClusterer("place", nil, places)
+	require.NoError(t, err)
+	require.Nil(t, c)
+	require.False(t, c.applies(0))
+
+	_, err = newClusterer("place", &config.Cluster{Radius: 0}, places)
+	require.Error(t, err)
+
+	_, err = newClusterer("place", &config.Cluster{Mode: "kmeans", Radius: 10}, places)
+	require.Error(t, err)
+
+	_, err = newClusterer("place", &config.Cluster{Radius: 10, Aggregates: []config.Aggregate{{Column: "pop", Function: "median"}}}, places)
+	require.Error(t, err)
+
+	// aggregates and the priority must be attributes of the layer
+	_, err = newClusterer("place", &config.Cluster{Radius: 10, Aggregates: []config.Aggregate{{Column: "area", Function: "sum"}}}, places)
+	require.Error(t, err)
+
+	_, err = newClusterer("place", &config.Cluster{Radius: 10, Priority: "importance"}, places)
+	require.Error(t, err)
+
+	z := 10
+	c, err = newClusterer("place", &config.Cluster{MaxZoom: &z, Radius: 10, Aggregates: []config.Aggregate{{Column: "pop", Function: "sum"}}}, places)
+	require.NoError(t, err)
+	require.Equal(t, ClusterGrid, c.mode)
+	require.Equal(t, "pop_sum", c.aggregates[0].name)
+	require.True(t, c.applies(10))
+	require.False(t, c.applies(11))
+
+	require.Equal(t, []Attribute{
+		{Name: pointCount, Type: TypeInteger, MaxZoom: &z},
+		{Name: "pop_sum", Type: TypeInteger, MaxZoom: &z},
+	}, c.attributes(places))
+}
+
+func TestClusterGrid(t *testing.T) {
+	c, err := newClusterer("place", &config.Cluster{
+		Radius:   10,
+		Priority: "pop",
+		Aggregates: []config.Aggregate{
+			{Column: "pop", Function: "sum"},
+			{Column: "pop", Function: "max"},
+			{Name: "name", Column: "name", Function: "first"},
+		},
+	}, places)
+	require.NoError(t, err)
+
+	line := &feature{geom: &geom.LineString{Coordinates: []geom.Coordinate{{0, 0}, {5, 5}}}}
+
+	features := c.cluster([]*feature{
+		point(1, 1, property{"pop", int64(10)}, property{"name", "a"}),
+		line,
+		point(9, 9, property{"pop", int64(30)}, property{"name", "b"}),
+		point(25, 25, property{"pop", int64(5)}, property{"name", "c"}),
+	}, geom.Coordinate{0, 0}, 100)
+
+	require.Len(t, features, 3)
+	require.Equal(t, line, features[0])
+
+	require.Equal(t, geom.Coordinate{5, 5}, features[1].geom.(*geom.Point).Coordinate)
+	require.Equal(t, []property{
+		{pointCount, int64(2)},
+		{"pop_sum", int64(40)},
+		{"pop_max", int64(30)},
+		{"name", "b"},
+	}, features[1].props)
+
+	// a point on its own is kept as is
+	require.Equal(t, []property{{"pop", int64(5)}, {"name", "c"}}, features[2].props)
+}
+
+func TestClusterDistance(t *testing.T) {
+	c, err := newClusterer("place", &config.Cluster{Mode: ClusterDistance, Radius: 10, Priority: "rank"}, places)
+	require.NoError(t, err)
+
+	// the highest ranked point takes the points within the radius first, the grid cells don't matter
+	features := c.cluster([]*feature{
+		point(0, 0, property{"rank", int64(1)}),
+		point(12, 0, property{"rank", int64(2)}),
+		point(21, 0, property{"rank", int64(3)}),
+		point(35, 0),
+	}, geom.Coordinate{0, 0}, 100)
+
+	require.Len(t, features, 3)
+	require.Equal(t, geom.Coordinate{16.5, 0}, features[0].geom.(*geom.Point).Coordinate)
+	require.Equal(t, []property{{pointCount, int64(2)}}, features[0].props)
+	require.Equal(t, geom.Coordinate{0, 0}, features[1].geom.(*geom.Point).Coordinate)
+	require.Equal(t, geom.Coordinate{35, 0}, features[2].geom.(*geom.Point).Coordinate)
+}
+
+func TestClusterTiles(t *testing.T) {
+	c, err := newClusterer("place", &config.Cluster{Radius: 30}, places)
+	require.NoError(t, err)
+
+	// points in world pixels either side of the edge between two tiles of extent 100, which the radius doesn't divide
+	world := []geom.Coordinate{{50, 10}, {92, 10}, {98, 12}, {104, 10}, {110, 14}}
+
+	// clusters the points read by the tile at the origin, a cell past the tile
+	tile := func(o geom.Coordinate) []*feature {
+		read := newClipper(100, 30)
+
+		var features []*feature
+		for _, w := range world {
+			if f := point(w[0]-o[0], w[1]-o[1]); read.clip(f.geom) != nil {
+				features = append(features, f)
+			}
+		}
+
+		return c.cluster(features, o, 100)
+	}
+
+	// the group straddling the edge is only served by the tile holding its centre
+	left := tile(geom.Coordinate{0, 0})
+	require.Len(t, left, 1)
+	require.Equal(t, geom.Coordinate{50, 10}, left[0].geom.(*geom.Point).Coordinate)
+	require.Nil(t, left[0].props)
+
+	right := tile(geom.Coordinate{100, 0})
+	require.Len(t, right, 1)
+	require.Equal(t, geom.Coordinate{1, 11.5}, right[0].geom.(*geom.Point).Coordinate)
+	require.Equal(t, []property{{pointCount, int64(4)}}, right[0].props)
+}
+
+func TestAggregate(t *testing.T) {
+	features := []*feature{
+		point(0, 0, property{"v", int64(2)}),
+		point(0, 0, property{"v", 3.5}),
+		point(0, 0, property{"v", "x"}),
+		point(0, 0),
+	}
+
+	apply := func(fn string) interface{} {
+		return aggregate{column: "v", function: fn}.apply(features)
+	}
+
+	require.Equal(t, int64(3), apply(AggregateCount))
+	require.Equal(t, 5.5, apply(AggregateSum))
+	require.Equal(t, 2.75, apply(AggregateAvg))
+	require.Equal(t, int64(2), apply(AggregateMin))
+	require.Equal(t, 3.5, apply(AggregateMax))
+	require.Equal(t, int64(2), apply(AggregateFirst))
+
+	require.Nil(t, aggregate{column: "w", function: AggregateSum}.apply(features))
+}
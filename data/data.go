@@ -2,8 +2,10 @@ package data
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"math"
 	"strings"
@@ -15,37 +17,97 @@ import (
 
 	"github.com/devork/geom"
 	"github.com/devork/geom/ewkb"
+	"github.com/golang/protobuf/proto"
 	"github.com/jackc/pgx"
 
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	// TODO: make this configurable
-	tileSize uint32 = 4096
+	// default tile extent (resolution) of a layer
+	defaultExtent uint32 = 4096
+
+	// default clip buffer in tile pixels
+	defaultBuffer uint32 = 64
+
+	// default maximum number of features read for a layer
+	defaultLimit = 20000
+
+	// SRID of the tiles, geometries stored in any other SRID are transformed
+	tileSRID int32 = 3857
+
+	// default number of layers queried at the same time for a tile
+	defaultParallelism = 4
 
 	// vector version:
 	mvtVersion = uint32(2)
-
-	point      = vtile.Tile_POINT
-	polygon    = vtile.Tile_POLYGON
-	linestring = vtile.Tile_LINESTRING
 )
 
 // Common errors
 var (
 	ErrNoSuchSource = errors.New("no such source with given name")
+	ErrCancelled    = errors.New("tile request cancelled")
+	ErrTimeout      = errors.New("tile query timed out")
+)
+
+// Tile backends: tiles are either built by grava from the geometry and attribute rows, or by PostGIS itself using
+// ST_AsMVT (PostGIS 3+).
+const (
+	BackendGo      = "go"
+	BackendPostGIS = "postgis"
 )
 
 // Db holds the Database connection
 type Db struct {
-	db      pgx.ConnPool
-	sources map[string][]*Layer
+	db      *pool
+	sources map[string]*source
+}
+
+// source is a set of layers served together and the backend which builds the tiles
+type source struct {
+	backend     string
+	parallelism int
+	timeout     time.Duration
+	maxSize     int
+	layers      []*Layer
+}
+
+// context applies the tile timeout (if any) to ctx
+func (s *source) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout > 0 {
+		return context.WithTimeout(ctx, s.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// over checks if the encoded tile is over the size budget of the source
+func (s *source) over(data []byte) bool {
+	return s.maxSize > 0 && len(data) > s.maxSize
+}
+
+// visible returns the layers served at the given zoom
+func (s *source) visible(zoom int) []*Layer {
+	layers := make([]*Layer, 0, len(s.layers))
+	for _, layer := range s.layers {
+		if layer.Visible(zoom) {
+			layers = append(layers, layer)
+		}
+	}
+	return layers
 }
 
 // Sources provides a list of the source data this Db instance is managing.
 func (d *Db) Sources() map[string][]*Layer {
-	return d.sources
+	sources := make(map[string][]*Layer, len(d.sources))
+	for name, src := range d.sources {
+		sources[name] = src.layers
+	}
+	return sources
+}
+
+// Stats reports the usage of the database connection pool
+func (d *Db) Stats() PoolStats {
+	return d.db.stats()
 }
 
 // Close will release all resources associated with the database
@@ -53,116 +115,349 @@ func (d *Db) Close() {
 	d.db.Close()
 }
 
-// FetchTile queries the database for those features which intersect the given BBOX and the specified layer(s)
-func (d *Db) FetchTile(box *geo.BBox, name string) (*vtile.Tile, error) {
+// FetchTile queries the database for those features which intersect the given BBOX (at the given zoom) and the
+// specified layer(s). Queries are cancelled with the context; ErrCancelled or ErrTimeout are returned when the
+// context (or the configured timeouts) end the request.
+func (d *Db) FetchTile(ctx context.Context, box *geo.BBox, zoom int, name string) (*vtile.Tile, error) {
+	src, ok := d.sources[name]
+
+	if !ok {
+		return nil, ErrNoSuchSource
+	}
+
+	contents, _, err := d.readTile(ctx, src, box, zoom)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return encode(contents), nil
+}
+
+// readTile reads the visible layers of the source, also returning the names of the layers cut short by their limit
+func (d *Db) readTile(ctx context.Context, src *source, box *geo.BBox, zoom int) ([]*content, []string, error) {
+	ctx, cancel := src.context(ctx)
+	defer cancel()
+
+	layers := src.visible(zoom)
+
+	log.Debugf("Fetching tile data: bbox = %s, layers = %d", box.GoString(), len(layers))
+
+	byLayer := make([][]*content, len(layers))
+	truncated := make([]bool, len(layers))
+	err := parallel(ctx, len(layers), src.parallelism, func(ctx context.Context, idx int) error {
+		ctx, cancel := layers[idx].context(ctx)
+		defer cancel()
+
+		cs, more, err := d.readLayer(ctx, layers[idx], box, zoom)
+
+		if err != nil {
+			return outcome(ctx, err)
+		}
+
+		byLayer[idx] = cs
+		truncated[idx] = more
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, outcome(ctx, err)
+	}
+
+	var contents []*content
+	for _, cs := range byLayer {
+		contents = append(contents, cs...)
+	}
+
+	return contents, names(layers, truncated), nil
+}
+
+// names returns the names of the layers flagged
+func names(layers []*Layer, flags []bool) []string {
+	var out []string
+	for idx, flag := range flags {
+		if flag {
+			out = append(out, layers[idx].Name)
+		}
+	}
+	return out
+}
 
-	layers, ok := d.sources[name]
+// MVT is an encoded tile and the details of how it was built
+type MVT struct {
+	Data []byte
+
+	// Truncated lists the layers which had more features than their limit, the extra features are not in the tile
+	Truncated []string
+
+	// Degraded lists the steps taken to bring the tile within the size budget of its source
+	Degraded []string
+
+	// OverBudget is set when the tile is still over the size budget of its source once every step has been taken
+	OverBudget bool
+}
+
+// FetchMVT returns the encoded tile for the given BBOX (at the given zoom) and source. Depending on the backend of
+// the source, the tile is either built by FetchTile or by PostGIS with ST_AsMVT.
+func (d *Db) FetchMVT(ctx context.Context, box *geo.BBox, zoom int, name string) (*MVT, error) {
+	src, ok := d.sources[name]
 
 	if !ok {
 		return nil, ErrNoSuchSource
 	}
 
-	// tile pixel width in ground units
-	width := float64(tileSize) / (box.Maxx - box.Minx)
-	height := float64(tileSize) / (box.Maxy - box.Miny)
+	if src.backend != BackendPostGIS {
+		contents, truncated, err := d.readTile(ctx, src, box, zoom)
 
-	// next tile instance
-	tile := vtile.Tile{}
-	tile.Layers = []*vtile.Tile_Layer{}
-	var vlayer *vtile.Tile_Layer
-	var err error
+		if err != nil {
+			return nil, err
+		}
 
-	log.Debugf("Fetching tile data: bbox = %s, name = %s, width = %f, height = %f", box.GoString(), name, width, height)
-	for _, layer := range layers {
-		vlayer, err = d.readLayer(layer, box, width, height)
+		data, err := proto.Marshal(encode(contents))
 
 		if err != nil {
 			return nil, err
 		}
-		tile.Layers = append(tile.Layers, vlayer)
+
+		var steps []string
+		if src.over(data) {
+			before := len(data)
+
+			if data, steps, err = degrade(contents, data, src.maxSize); err != nil {
+				return nil, err
+			}
+
+			logDegraded(box, name, before, len(data), src.maxSize, steps)
+		}
+
+		return &MVT{Data: data, Truncated: truncated, Degraded: steps, OverBudget: src.over(data)}, nil
 	}
 
-	return &tile, nil
+	log.Debugf("Fetching MVT: bbox = %s, name = %s", box.GoString(), name)
+
+	ctx, cancel := src.context(ctx)
+	defer cancel()
+
+	layers := src.visible(zoom)
+
+	mvts := make([][]byte, len(layers))
+	truncated := make([]bool, len(layers))
+	err := parallel(ctx, len(layers), src.parallelism, func(ctx context.Context, idx int) error {
+		ctx, cancel := layers[idx].context(ctx)
+		defer cancel()
+
+		mvt, more, err := d.readMVT(ctx, layers[idx], box, zoom)
+
+		if err != nil {
+			return outcome(ctx, err)
+		}
+
+		mvts[idx] = mvt
+		truncated[idx] = more
+		return nil
+	})
+
+	if err != nil {
+		return nil, outcome(ctx, err)
+	}
+
+	// each layer is a complete layer message, so can just be appended to form the tile
+	var data []byte
+	for _, mvt := range mvts {
+		data = append(data, mvt...)
+	}
+
+	// the layers are already encoded, so the only step available is to drop optional layers
+	var steps []string
+	if src.over(data) {
+		before := len(data)
+
+		for idx := len(layers) - 1; idx >= 0 && len(data) > src.maxSize; idx-- {
+			if !layers[idx].optional || len(mvts[idx]) == 0 {
+				continue
+			}
+
+			mvts[idx] = nil
+			steps = []string{DegradeLayers}
+
+			data = nil
+			for _, mvt := range mvts {
+				data = append(data, mvt...)
+			}
+		}
+
+		logDegraded(box, name, before, len(data), src.maxSize, steps)
+	}
+
+	return &MVT{Data: data, Truncated: names(layers, truncated), Degraded: steps, OverBudget: src.over(data)}, nil
+}
+
+// outcome maps an error to ErrCancelled or ErrTimeout when it was caused by the context ending
+func outcome(ctx context.Context, err error) error {
+	switch ctx.Err() {
+	case context.Canceled:
+		return ErrCancelled
+	case context.DeadlineExceeded:
+		return ErrTimeout
+	}
+	return err
+}
+
+// readMVT runs the ST_AsMVT query for a layer, also reporting if the layer was cut short by its limit
+func (d *Db) readMVT(ctx context.Context, lyr *Layer, box *geo.BBox, zoom int) ([]byte, bool, error) {
+	query := grow(box, lyr.buffer, lyr.extent)
+
+	var mvt []byte
+	var count int64
+	err := d.db.QueryRowEx(ctx, lyr.mvtQuery, nil, args(lyr.mvtParams, box, query, zoom, lyr.extent)...).Scan(&mvt, &count)
+
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to query layer: layer = %s, error = %s", lyr.Name, err)
+	}
+
+	truncated := lyr.limit > 0 && count > int64(lyr.limit)
+	if truncated {
+		log.Warnf("layer truncated by feature limit: bbox = %s, layer = %s, limit = %d", box.GoString(), lyr.Name, lyr.limit)
+	}
 
+	return mvt, truncated, nil
 }
 
-func (d *Db) readLayer(lyr *Layer, box *geo.BBox, width, height float64) (*vtile.Tile_Layer, error) {
-	bx := (box.Maxx - box.Minx) * 0.05
-	by := (box.Maxy - box.Miny) * 0.05
-	log.Debugf("Reading Layer: bbox = %s, name = %s, bx = %f, by = %f", box.GoString(), lyr.Name, bx, by)
-	rows, err := d.db.Query(
+// readLayer reads the features of a layer (followed by its label layer, if it has one), also reporting if the layer was
+// cut short by its limit
+func (d *Db) readLayer(ctx context.Context, lyr *Layer, box *geo.BBox, zoom int) ([]*content, bool, error) {
+	clip := newClipper(lyr.extent, lyr.buffer)
+	gen := newGeneralizer(lyr.generalize, zoom)
+
+	// label points are found to within a tile pixel, the same size in every tile of the zoom
+	precision := worldSize / math.Exp2(float64(zoom)) / float64(lyr.extent)
+
+	// tile pixels per ground unit
+	width := float64(lyr.extent) / (box.Maxx - box.Minx)
+	height := float64(lyr.extent) / (box.Maxy - box.Miny)
+
+	// the layer may be read past its buffer (see margin) and clipped to the buffer once its features have been reworked
+	margin := lyr.margin(zoom)
+	outer := clip
+	if margin != lyr.buffer {
+		outer = newClipper(lyr.extent, margin)
+	}
+
+	query := grow(box, margin, lyr.extent)
+	log.Debugf("Reading Layer: bbox = %s, name = %s, query = %s", box.GoString(), lyr.Name, query.GoString())
+	rows, err := d.db.QueryEx(
+		ctx,
 		lyr.query,
-		box.Minx-bx, box.Miny-by, box.Maxx+bx, box.Maxy+by, box.Srid,
+		nil,
+		args(lyr.params, box, query, zoom, lyr.extent)...,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	defer rows.Close()
 
 	desc := rows.FieldDescriptions()
 
-	// key -> index
 	skips := []int{}
 	keys := make([]string, len(desc))
+	columns := map[string]bool{}
+	expanders := map[int]*expander{}
 	for idx, key := range desc {
 
 		if key.Name == "geom" || key.Name == "geometry" {
 			skips = append(skips, idx)
 		}
+
+		// id follows the geometry
+		if idx == 1 && lyr.ID != "" {
+			skips = append(skips, idx)
+		}
+
+		if e, ok := lyr.expanders[key.Name]; ok {
+			expanders[idx] = e
+		}
+
 		keys[idx] = key.Name
+		columns[key.Name] = true
 	}
 
-	// value -> key index
-	values := map[interface{}]int{}
-	vcount := 0
+	merging := lyr.merge.applies(zoom)
 
 	var ins []interface{}
-
-	var tileLayer = vtile.Tile_Layer{}
-	tileLayer.Version = &mvtVersion
-	tileLayer.Name = &lyr.Name
-	tileLayer.Extent = &tileSize
-
-	var features = make([]*vtile.Tile_Feature, 0)
+	var features, labels []*feature
 	var r io.Reader
 
+	// the query reads one row past the limit to tell if the layer has been truncated
+	count := 0
+	truncated := false
+
 	for rows.Next() {
+		count++
+		if lyr.limit > 0 && count > lyr.limit {
+			truncated = true
+			log.Warnf("layer truncated by feature limit: bbox = %s, layer = %s, limit = %d", box.GoString(), lyr.Name, lyr.limit)
+			break
+		}
+
 		ins, err = rows.Values()
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 
 		r = bytes.NewReader(ins[0].([]byte))
 		g, err := ewkb.Decode(r)
 
 		if err != nil {
-			return nil, err
+			return nil, false, err
+		}
+
+		// collections are split into a feature per member, sharing the id and properties of the row
+		var geoms, points []geom.Geometry
+
+		for _, g := range members(g) {
+			if lyr.labels != nil {
+				if p := lyr.labels.label(g, precision); p != nil {
+					// only the tile holding the point serves it
+					p := project(p, box, width, height).(*geom.Point)
+					if x, y := p.Coordinate[0], p.Coordinate[1]; x >= 0 && y >= 0 && x < float64(lyr.extent) && y < float64(lyr.extent) {
+						points = append(points, p)
+					}
+				}
+			}
+
+			g = project(g, box, width, height)
+
+			// features to be merged are only clipped to the read margin, they are generalized once merged, see rework
+			if merging {
+				g = outer.clip(g)
+			} else {
+				g = shape(g, gen, outer)
+			}
+
+			if g == nil {
+				continue
+			}
+
+			geoms = append(geoms, g)
 		}
 
-		var feature *vtile.Tile_Feature
-
-		switch g.(type) {
-		case *geom.Point:
-			feature = readPoint(g.(*geom.Point), box, width, height)
-		case *geom.MultiPoint:
-			feature = readMultiPoint(g.(*geom.MultiPoint), box, width, height)
-		case *geom.Polygon:
-			feature = readPolygon(g.(*geom.Polygon), box, width, height)
-		case *geom.MultiPolygon:
-			feature = readMultiPolygon(g.(*geom.MultiPolygon), box, width, height)
-		case *geom.LineString:
-			feature = readLinestring(g.(*geom.LineString), box, width, height)
-		case *geom.MultiLineString:
-			feature = readMultiLinestring(g.(*geom.MultiLineString), box, width, height)
-		default:
-			log.Warn("unsupported geometry type", "geometry", g.Type())
+		if len(geoms) == 0 && len(points) == 0 {
 			continue
 		}
 
+		var id *uint64
+		if lyr.ID != "" {
+			if v, ok := featureID(ins[1]); ok {
+				id = &v
+			}
+		}
+
+		var props []property
+
 	vloop:
-		for idx, value := range ins {
+		for idx, v := range ins {
 
 			for _, skip := range skips {
 				if idx == skip {
@@ -170,427 +465,637 @@ func (d *Db) readLayer(lyr *Layer, box *geo.BBox, width, height float64) (*vtile
 				}
 			}
 
-			if value == nil {
+			if v == nil {
+				continue
+			}
+
+			e, ok := expanders[idx]
+
+			if !ok {
+				props = append(props, property{keys[idx], v})
 				continue
 			}
 
-			if v, ok := value.([]string); ok {
-				value = strings.Join(v, ",")
+			expanded, err := e.expand(v.(string))
+
+			if err != nil {
+				log.Warnf("failed to expand column: layer = %s, column = %s, error = %s", lyr.Name, keys[idx], err)
+				continue
 			}
 
-			valuesIdx, ok := values[value]
+			for _, p := range expanded {
+				// columns take precedence over expanded keys of the same name
+				if columns[p.key] {
+					continue
+				}
 
-			if !ok {
-				values[value] = vcount
-				valuesIdx = vcount
-				vcount++
+				props = append(props, p)
 			}
+		}
 
-			feature.Tags = append(feature.Tags, uint32(idx), uint32(valuesIdx))
+		for _, g := range geoms {
+			features = append(features, &feature{geom: g, id: id, props: props})
 		}
 
-		features = append(features, feature)
+		for _, p := range points {
+			labels = append(labels, &feature{geom: p, id: id, props: props})
+		}
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	tileLayer.Features = features
-	tileLayer.Keys = keys
-	tileLayer.Values = make([]*vtile.Tile_Value, len(values))
-
-	// create values
-	for v, i := range values {
-		switch v.(type) {
-		case string:
-			var str = v.(string)
-			tileLayer.Values[i] = &vtile.Tile_Value{StringValue: &str}
-		case float32:
-			var f32 = v.(float32)
-			tileLayer.Values[i] = &vtile.Tile_Value{FloatValue: &f32}
-		case float64:
-			var f64 = v.(float64)
-			tileLayer.Values[i] = &vtile.Tile_Value{DoubleValue: &f64}
-		case int:
-			var i32 = int64(v.(int))
-			tileLayer.Values[i] = &vtile.Tile_Value{IntValue: &i32}
-		case int32:
-			var i32 = int64(v.(int32))
-			tileLayer.Values[i] = &vtile.Tile_Value{IntValue: &i32}
-		case uint, uint32, uint64:
-			var u64 = v.(uint64)
-			tileLayer.Values[i] = &vtile.Tile_Value{UintValue: &u64}
-		case int64:
-			var i64 = v.(int64)
-			tileLayer.Values[i] = &vtile.Tile_Value{SintValue: &i64}
-		case bool:
-			var b = v.(bool)
-			tileLayer.Values[i] = &vtile.Tile_Value{BoolValue: &b}
-		case time.Time:
-			str := fmt.Sprint(v)
-			tileLayer.Values[i] = &vtile.Tile_Value{StringValue: &str}
-		default:
-			str := fmt.Sprint(v)
-			tileLayer.Values[i] = &vtile.Tile_Value{StringValue: &str}
-			log.Warn("unexpected type", "value", v, "type", fmt.Sprintf("%T", v))
-		}
+	features = lyr.rework(features, zoom, origin(box, lyr.extent), gen, outer, clip)
+
+	contents := []*content{{lyr: lyr, name: lyr.Name, features: features}}
+
+	if lyr.labels != nil {
+		contents = append(contents, &content{lyr: lyr, name: lyr.labels.layer, features: labels})
 	}
 
-	return &tileLayer, nil
+	return contents, truncated, nil
 }
 
-func readPoint(g *geom.Point, box *geo.BBox, width, height float64) *vtile.Tile_Feature {
-	var feature = vtile.Tile_Feature{}
-	feature.Type = &point
+// shape generalizes (if there is a generalizer) and clips a projected geometry, returning nil if nothing is left
+func shape(g geom.Geometry, gen *generalizer, clip *clipper) geom.Geometry {
+	if gen != nil {
+		if g = gen.generalize(g); g == nil {
+			return nil
+		}
+	}
 
-	// convert to coords
-	gx := g.Coordinate[0]
-	gy := g.Coordinate[1]
+	return clip.clip(g)
+}
 
-	x := int32(math.Floor((gx - box.Minx) * width))
-	y := int32(math.Floor((gy - box.Miny) * height))
+// rework clusters, merges and bins the features read for the tile, as configured for the zoom, and clips them to the
+// buffer. The origin is the position of the tile in world pixels, see origin. Features read for merging have been
+// clipped to the read margin but not generalized, so the edges shared by neighbouring features still meet exactly: the
+// merged features are generalized and clipped to the buffer instead.
+func (l *Layer) rework(features []*feature, zoom int, o geom.Coordinate, gen *generalizer, outer, clip *clipper) []*feature {
+	if l.cluster.applies(zoom) {
+		features = l.cluster.cluster(features, o, l.extent)
+	}
 
-	cmd := uint32(1&0x7 | 1<<3)
-	px := uint32(x<<1 ^ x>>31)
-	py := uint32(y<<1 ^ y>>31)
-	feature.Geometry = []uint32{cmd, px, py}
+	if l.merge.applies(zoom) {
+		merged := l.merge.merge(features)
 
-	return &feature
-}
+		features = features[:0]
+		for _, f := range merged {
+			if f.geom = shape(f.geom, gen, clip); f.geom != nil {
+				features = append(features, f)
+			}
+		}
+	}
 
-func readMultiPoint(mp *geom.MultiPoint, box *geo.BBox, width, height float64) *vtile.Tile_Feature {
-	var feature = vtile.Tile_Feature{}
-	feature.Type = &point
+	if l.bins != nil {
+		features = l.bins.bin(features, o, l.extent)
+	}
 
-	for _, g := range mp.Points {
+	// features read past the buffer, and cells on the edge of the tile, are clipped to the buffer
+	if outer != clip || l.bins != nil {
+		clipped := features[:0]
+		for _, f := range features {
+			if f.geom = clip.clip(f.geom); f.geom != nil {
+				clipped = append(clipped, f)
+			}
+		}
+		features = clipped
+	}
 
-		// convert to coords
-		gx := g.Coordinate[0]
-		gy := g.Coordinate[1]
+	return features
+}
 
-		x := int32(math.Floor((gx - box.Minx) * width))
-		y := int32(math.Floor((gy - box.Miny) * height))
+// members flattens geometry collections (including nested collections) into their member geometries. Any other
+// geometry is returned as is.
+func members(g geom.Geometry) []geom.Geometry {
+	gc, ok := g.(*geom.GeometryCollection)
 
-		cmd := uint32(1&0x7 | 1<<3)
-		px := uint32(x<<1 ^ x>>31)
-		py := uint32(y<<1 ^ y>>31)
-		feature.Geometry = []uint32{cmd, px, py}
+	if !ok {
+		return []geom.Geometry{g}
 	}
 
-	return &feature
+	var out []geom.Geometry
+	for _, member := range gc.Geometries {
+		out = append(out, members(member)...)
+	}
+	return out
 }
 
-func readLinestring(g *geom.LineString, box *geo.BBox, width, height float64) *vtile.Tile_Feature {
-	var feature = vtile.Tile_Feature{}
-	var gx float64
-	var gy float64
-	var dx int32
-	var dy int32
-	var cmds = []uint32{}
-	var x int32
-	var y int32
-	var lx int32
-	var ly int32
+// grow returns the tile box grown by the buffer, converted from tile pixels (of the given extent) to ground units (the
+// sign follows the axis direction so this works for the inverted 'y' of geo.NewBBox)
+func grow(box *geo.BBox, buffer, extent uint32) *geo.BBox {
+	bx := float64(buffer) * (box.Maxx - box.Minx) / float64(extent)
+	by := float64(buffer) * (box.Maxy - box.Miny) / float64(extent)
 
-	// moveto
-	gx = g.Coordinates[0][0]
-	gy = g.Coordinates[0][1]
+	return &geo.BBox{Minx: box.Minx - bx, Miny: box.Miny - by, Maxx: box.Maxx + bx, Maxy: box.Maxy + by, Srid: box.Srid}
+}
 
-	x = int32(math.Floor((gx - box.Minx) * width))
-	y = int32(math.Floor((gy - box.Miny) * height))
+// origin returns the position of the top left of the tile in world pixels (of the extent, at the zoom of the box), so
+// grids can be laid over the world rather than each tile and neighbouring tiles agree on them
+func origin(box *geo.BBox, extent uint32) geom.Coordinate {
+	width := float64(extent) / (box.Maxx - box.Minx)
+	height := float64(extent) / (box.Maxy - box.Miny)
 
-	dx = x - lx
-	dy = y - ly
+	// tile space of the world's top left corner, projected as the geometries are
+	return geom.Coordinate{math.Round((box.Minx + worldSize/2) * width), math.Round((box.Miny - worldSize/2) * height)}
+}
 
-	cmd := uint32(1&0x7 | 1<<3)
-	cmds = append(cmds, cmd, uint32(dx<<1^dx>>31), uint32(dy<<1^dy>>31))
+// NewDb opens the database specified at the given path
+func NewDb(cfg *config.Config) (*Db, error) {
 
-	lx = x
-	ly = y
+	if cfg.Postgres == "" {
+		return nil, errors.New("no postgres configuration specified")
+	}
 
-	// lineto
-	// (-1) as we have consumed the first point
-	cmd = uint32(2&0x07 | (len(g.Coordinates)-1)<<3)
-	cmds = append(cmds, cmd)
+	var pcon pgx.ConnConfig
+	var err error
 
-	for idx := 1; idx < len(g.Coordinates); idx++ {
-		gx = g.Coordinates[idx][0]
-		gy = g.Coordinates[idx][1]
+	if strings.HasPrefix(cfg.Postgres, "postgres://") || strings.HasPrefix(cfg.Postgres, "postgresql://") {
+		pcon, err = pgx.ParseURI(cfg.Postgres)
 
-		x = int32(math.Floor((gx - box.Minx) * width))
-		y = int32(math.Floor((gy - box.Miny) * height))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse connection uri: %s", err)
+		}
+	} else {
+		pcon, err = pgx.ParseDSN(cfg.Postgres)
 
-		dx = x - lx
-		dy = y - ly
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse connection DSN: %s", err)
+		}
+	}
 
-		lx = x
-		ly = y
+	pcfg, err := poolConfig(pcon, cfg.Database)
 
-		cmds = append(cmds, uint32(dx<<1^dx>>31), uint32(dy<<1^dy>>31))
+	if err != nil {
+		return nil, err
 	}
 
-	feature.Geometry = cmds
-	feature.Type = &linestring
+	db, err := pgx.NewConnPool(pcfg)
 
-	return &feature
+	if err != nil {
+		return nil, err
+	}
+
+	sources := map[string]*source{}
+	for _, src := range cfg.Sources {
+		backend := src.Backend
+		if backend == "" {
+			backend = BackendGo
+		}
+
+		if backend != BackendGo && backend != BackendPostGIS {
+			return nil, fmt.Errorf("unknown source backend: source = %s, backend = %s", src.Name, backend)
+		}
+
+		parallelism := defaultParallelism
+		if src.Parallelism > 0 {
+			parallelism = src.Parallelism
+		}
+
+		sources[src.Name] = &source{
+			backend:     backend,
+			parallelism: parallelism,
+			timeout:     time.Duration(src.Timeout),
+			maxSize:     src.MaxSize,
+			layers:      make([]*Layer, len(src.Layers)),
+		}
+
+		for idx, lyr := range src.Layers {
+			sources[src.Name].layers[idx], err = read(db, src, lyr, cfg.Schema)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &Db{&pool{ConnPool: db}, sources}, nil
 }
 
-func readMultiLinestring(mls *geom.MultiLineString, box *geo.BBox, width, height float64) *vtile.Tile_Feature {
-	var feature = vtile.Tile_Feature{}
-	var gx float64
-	var gy float64
-	var dx int32
-	var dy int32
-	var cmds = []uint32{}
-	var x int32
-	var y int32
-	var lx int32
-	var ly int32
+// column is a column of a layer's table (or SQL), its Postgres type name and type category
+type column struct {
+	name     string
+	udt      string
+	category string
+}
 
-	for _, g := range mls.LineStrings {
+// Queries the specified layer to obtain metadata about the table (or the layer SQL).
+func read(db *pgx.ConnPool, src config.Source, lyr config.Layer, schema string) (*Layer, error) {
+	layer := lyr.Name
+	prefix := src.Prefix
+
+	buffer := defaultBuffer
+	switch {
+	case lyr.Buffer != nil:
+		buffer = *lyr.Buffer
+	case src.Buffer != nil:
+		buffer = *src.Buffer
+	}
 
-		// moveto
-		gx = g.Coordinates[0][0]
-		gy = g.Coordinates[0][1]
+	extent := defaultExtent
+	switch {
+	case lyr.Extent > 0:
+		extent = lyr.Extent
+	case src.Extent > 0:
+		extent = src.Extent
+	}
 
-		x = int32(math.Floor((gx - box.Minx) * width))
-		y = int32(math.Floor((gy - box.Miny) * height))
+	limit := defaultLimit
+	switch {
+	case lyr.Limit != nil:
+		limit = *lyr.Limit
+	case src.Limit != nil:
+		limit = *src.Limit
+	}
 
-		dx = x - lx
-		dy = y - ly
+	if lyr.Precision != nil && (*lyr.Precision < 0 || *lyr.Precision > vtile.MaxPrecision) {
+		return nil, fmt.Errorf("precision out of range: layer = %s, precision = %d, max = %d",
+			layer, *lyr.Precision, vtile.MaxPrecision)
+	}
 
-		cmd := uint32(1&0x7 | 1<<3)
-		cmds = append(cmds, cmd, uint32(dx<<1^dx>>31), uint32(dy<<1^dy>>31))
+	var columns []column
+	var err error
 
-		lx = x
-		ly = y
+	if lyr.SQL != "" {
+		columns, err = queryColumns(db, lyr.SQL)
+	} else {
+		columns, err = tableColumns(db, schema, prefix+layer)
+	}
 
-		// lineto
-		// (-1) as we have consumed the first point
-		cmd = uint32(2&0x07 | (len(g.Coordinates)-1)<<3)
-		cmds = append(cmds, cmd)
+	if err != nil {
+		return nil, err
+	}
 
-		for idx := 1; idx < len(g.Coordinates); idx++ {
-			gx = g.Coordinates[idx][0]
-			gy = g.Coordinates[idx][1]
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no data found for layer: name = %s", layer)
+	}
 
-			x = int32(math.Floor((gx - box.Minx) * width))
-			y = int32(math.Floor((gy - box.Miny) * height))
+	udts := map[string]string{}
 
-			dx = x - lx
-			dy = y - ly
+	var geom string
 
-			lx = x
-			ly = y
+	for _, c := range columns {
+		udts[c.name] = c.udt
 
-			cmds = append(cmds, uint32(dx<<1^dx>>31), uint32(dy<<1^dy>>31))
+		// first geometry column wins
+		if (c.udt == "geometry" || c.udt == "geography") && geom == "" {
+			geom = c.name
 		}
 	}
 
-	feature.Geometry = cmds
-	feature.Type = &linestring
+	if geom == "" {
+		return nil, fmt.Errorf("no geometry data found: layer = %s", layer)
+	}
 
-	return &feature
-}
+	// feature id: either configured or the (single column) primary key of the table
+	id := lyr.ID
+	switch {
+	case id != "":
+		udt, ok := udts[id]
 
-func readPolygon(g *geom.Polygon, box *geo.BBox, width, height float64) *vtile.Tile_Feature {
-	var feature = vtile.Tile_Feature{}
-	var gx float64
-	var gy float64
-	var dx int32
-	var dy int32
-	var cmds = []uint32{}
-	var x int32
-	var y int32
-	var lx int32
-	var ly int32
+		if !ok {
+			return nil, fmt.Errorf("id column not found: layer = %s, column = %s", layer, id)
+		}
+
+		if !idTypes[udt] {
+			return nil, fmt.Errorf("unsupported id column type: layer = %s, column = %s, type = %s", layer, id, udt)
+		}
+	case lyr.SQL == "":
+		id, err = primaryKey(db, schema, prefix+layer)
 
-	for _, ring := range g.Rings {
-		// moveto
-		gx = ring.Coordinates[0][0]
-		gy = ring.Coordinates[0][1]
+		if err != nil {
+			return nil, fmt.Errorf("could not determine primary key: layer = %s, err = %s", layer, err)
+		}
 
-		x = int32(math.Floor((gx - box.Minx) * width))
-		y = int32(math.Floor((gy - box.Miny) * height))
+		if id != "" && !idTypes[udts[id]] {
+			log.Debugf("ignoring primary key for feature id, unsupported type: layer = %s, column = %s, type = %s", layer, id, udts[id])
+			id = ""
+		}
+	}
 
-		dx = x - lx
-		dy = y - ly
+	sel, err := selectAttributes(layer, columns, map[string]bool{geom: true, id: true}, lyr)
 
-		cmd := uint32(1&0x7 | 1<<3)
-		cmds = append(cmds, cmd, uint32(dx<<1^dx>>31), uint32(dy<<1^dy>>31))
+	if err != nil {
+		return nil, err
+	}
 
-		//log.Printf("moveto: %d %d => %d %d", x, y, dx, dy)
+	attrs, selects := sel.attrs, sel.selects
 
-		lx = x
-		ly = y
+	cluster, err := newClusterer(layer, lyr.Cluster, attrs)
 
-		// lineto
-		// (-2) as we have consumed the first point and ignoring the final point in the ring
-		cmd = uint32(2&0x07 | (len(ring.Coordinates)-2)<<3)
-		cmds = append(cmds, cmd)
+	if err != nil {
+		return nil, err
+	}
 
-		// ignore last coord as that is a close path
-		for idx := 1; idx < len(ring.Coordinates)-1; idx++ {
-			gx = ring.Coordinates[idx][0]
-			gy = ring.Coordinates[idx][1]
+	if cluster != nil {
+		if src.Backend == BackendPostGIS {
+			log.Warnf("clustering is not supported by ST_AsMVT, points will not be clustered: layer = %s", layer)
+		}
 
-			x = int32(math.Floor((gx - box.Minx) * width))
-			y = int32(math.Floor((gy - box.Miny) * height))
+		attrs = append(attrs, cluster.attributes(attrs)...)
+	}
 
-			dx = x - lx
-			dy = y - ly
+	merge, err := newMerger(layer, lyr.Merge)
 
-			lx = x
-			ly = y
+	if err != nil {
+		return nil, err
+	}
 
-			cmds = append(cmds, uint32(dx<<1^dx>>31), uint32(dy<<1^dy>>31))
+	if merge != nil {
+		// with ST_AsMVT every feature keeps its attributes, so they are only limited to the zooms above the merge when the
+		// features are merged
+		if src.Backend == BackendPostGIS {
+			log.Warnf("merging is not supported by ST_AsMVT, features will not be merged: layer = %s", layer)
+		} else if attrs, err = merge.attributes(layer, attrs); err != nil {
+			return nil, err
 		}
+	}
 
-		//closepath
-		cmd = uint32(7&0x07 | 1<<3)
-		cmds = append(cmds, cmd)
+	labels, err := newLabeller(layer, lyr.Labels)
+
+	if err != nil {
+		return nil, err
 	}
 
-	feature.Geometry = cmds
-	feature.Type = &polygon
+	var labelLayer string
+	if labels != nil {
+		if src.Backend == BackendPostGIS {
+			log.Warnf("label points are not supported by ST_AsMVT, no label layer will be served: layer = %s", layer)
+		}
 
-	return &feature
-}
+		labelLayer = labels.layer
+	}
 
-func readMultiPolygon(g *geom.MultiPolygon, box *geo.BBox, width, height float64) *vtile.Tile_Feature {
-	var feature = vtile.Tile_Feature{}
-	var gx float64
-	var gy float64
-	var dx int32
-	var dy int32
-	var cmds = []uint32{}
-	var x int32
-	var y int32
-	var lx int32
-	var ly int32
+	bins, err := newBinner(layer, lyr.Bins, attrs)
 
-	for _, poly := range g.Polygons {
+	if err != nil {
+		return nil, err
+	}
 
-		for _, ring := range poly.Rings {
-			// moveto
-			gx = ring.Coordinates[0][0]
-			gy = ring.Coordinates[0][1]
+	if bins != nil {
+		if cluster != nil {
+			return nil, fmt.Errorf("layer cannot be both clustered and binned: layer = %s", layer)
+		}
 
-			x = int32(math.Floor((gx - box.Minx) * width))
-			y = int32(math.Floor((gy - box.Miny) * height))
+		if merge != nil {
+			return nil, fmt.Errorf("layer cannot be both merged and binned: layer = %s", layer)
+		}
 
-			dx = x - lx
-			dy = y - ly
+		if src.Backend == BackendPostGIS {
+			return nil, fmt.Errorf("binned layers are not supported by the postgis backend: layer = %s", layer)
+		}
 
-			cmd := uint32(1&0x7 | 1<<3)
-			cmds = append(cmds, cmd, uint32(dx<<1^dx>>31), uint32(dy<<1^dy>>31))
+		// the layer serves the cells rather than the points
+		attrs = bins.attributes(attrs)
+	}
 
-			//log.Printf("moveto: %d %d => %d %d", x, y, dx, dy)
+	// low priority attributes are dropped first when a tile is over the size budget of the source, so must be among
+	// the attributes served by the layer
+	lowPriority := set(lyr.Attributes.LowPriority)
+	for name := range lowPriority {
+		if !hasAttribute(attrs, name) {
+			return nil, fmt.Errorf("low priority attribute not found: layer = %s, name = %s", layer, name)
+		}
+	}
 
-			lx = x
-			ly = y
+	// the id is always selected directly after the geometry
+	querySelects := selects
+	if id != "" {
+		querySelects = ", " + pgx.Identifier{id}.Sanitize() + selects
+	}
 
-			// lineto
-			// (-2) as we have consumed the first point and ignoring the final point in the ring
-			cmd = uint32(2&0x07 | (len(ring.Coordinates)-2)<<3)
-			cmds = append(cmds, cmd)
+	var from string
+	var conds []string
+	geomType := "GEOMETRY"
 
-			// ignore last coord as that is a close path
-			for idx := 1; idx < len(ring.Coordinates)-1; idx++ {
-				gx = ring.Coordinates[idx][0]
-				gy = ring.Coordinates[idx][1]
+	// the geometry as read for the tile, transformed to the tile SRID where the table is stored in another
+	geography := udts[geom] == "geography"
+	tileGeom := tileGeometry(geom, geography)
 
-				x = int32(math.Floor((gx - box.Minx) * width))
-				y = int32(math.Floor((gy - box.Miny) * height))
+	if lyr.SQL != "" {
+		from = fmt.Sprintf("(%s) q", lyr.SQL)
 
-				dx = x - lx
-				dy = y - ly
+		if geography {
+			tileGeom = fmt.Sprintf("st_transform(%s, %d)", tileGeom, tileSRID)
+		}
+	} else {
+		from = fmt.Sprintf("%s.%s%s", schema, prefix, layer)
 
-				lx = x
-				ly = y
+		// get the geometry type and SRID
+		var srid int32
+		err = db.QueryRow(geometryColumns[geography], schema, prefix+layer, geom).Scan(&geomType, &srid)
 
-				cmds = append(cmds, uint32(dx<<1^dx>>31), uint32(dy<<1^dy>>31))
-			}
+		if err != nil {
+			return nil, fmt.Errorf("cound not determine geometry data: layer = %s, err = %s", layer, err)
+		}
 
-			//close path
-			cmd = uint32(7&0x07 | 1<<3)
-			cmds = append(cmds, cmd)
+		if srid == 0 {
+			log.Warnf("geometry column has no SRID, assuming EPSG:%d: layer = %s, column = %s", tileSRID, layer, geom)
 		}
+
+		var bbox string
+		bbox, tileGeom = transform(tileGeom, srid)
+
+		conds = append(conds, intersects(geom, bbox, geography))
 	}
 
-	feature.Geometry = cmds
-	feature.Type = &polygon
+	filters, err := filterConditions(layer, lyr.Filter)
 
-	return &feature
-}
+	if err != nil {
+		return nil, err
+	}
 
-// NewDb opens the database specified at the given path
-func NewDb(cfg *config.Config) (*Db, error) {
+	conds = append(conds, filters...)
 
-	if cfg.Postgres == "" {
-		return nil, errors.New("no postgres configuration specified")
+	var where string
+	if len(conds) > 0 {
+		where = "where " + strings.Join(conds, " and ")
 	}
 
-	var pcon pgx.ConnConfig
-	var err error
+	if bins != nil {
+		geomType = "POLYGON"
+	}
 
-	if strings.HasPrefix(cfg.Postgres, "postgres://") || strings.HasPrefix(cfg.Postgres, "postgresql://") {
-		pcon, err = pgx.ParseURI(cfg.Postgres)
+	attrs = append(attrs, Attribute{Name: geom, Type: geomType})
 
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse connection uri: %s", err)
-		}
-	} else {
-		pcon, err = pgx.ParseDSN(cfg.Postgres)
+	// one row past the limit is read to tell if a layer has been truncated
+	var limits string
+	if limit > 0 {
+		limits = fmt.Sprintf("limit %d", limit+1)
+	}
 
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse connection DSN: %s", err)
+	// clipping happens in tile space once the geometry has been read, see clipper
+	query, params := bind(fmt.Sprintf(
+		`select 
+			ST_AsBinary(%s) as geom %s 
+		from 
+			%s 
+		%s 
+		%s`,
+		tileGeom, querySelects, from, where, limits,
+	))
+
+	// ST_AsMVT only takes integer feature ids
+	mvtSelects := selects
+	mvtID := ""
+	if id != "" {
+		if strings.HasPrefix(udts[id], "int") {
+			mvtID = fmt.Sprintf(", %s", quoteLiteral(id))
+			mvtSelects = querySelects
+		} else if src.Backend == BackendPostGIS {
+			log.Warnf("text feature ids are not supported by ST_AsMVT, ids will be dropped: layer = %s, column = %s", layer, id)
 		}
 	}
 
-	pcon.Logger = &logger{}
-	pcon.LogLevel = pgx.LogLevelError
+	// as above, the rows are counted to tell if the layer has been truncated
+	var mvtLimits string
+	if limit > 0 {
+		mvtLimits = fmt.Sprintf("limit %d", limit)
+	}
 
-	db, err := pgx.NewConnPool(
-		pgx.ConnPoolConfig{
-			ConnConfig:     pcon,
-			MaxConnections: 10,
-		},
-	)
+	mvtQuery, mvtParams := bind(fmt.Sprintf(
+		`with q as (
+			select 
+				ST_AsMVTGeom(%s, %s::box2d, %d, %d, true) as geom %s 
+			from 
+				%s 
+			%s 
+			%s
+		) 
+		select 
+			(select ST_AsMVT(t, %s, %d, 'geom'%s) from (select * from q %s) t where t.geom is not null), 
+			(select count(*) from q)`,
+		tileGeom, TokenTileBBox, extent, buffer, mvtSelects, from, where, limits,
+		quoteLiteral(layer), extent, mvtID, mvtLimits,
+	))
 
-	if err != nil {
-		return nil, err
+	return &Layer{
+		Name:        layer,
+		ID:          id,
+		MinZoom:     lyr.MinZoom,
+		MaxZoom:     lyr.MaxZoom,
+		Attributes:  attrs,
+		Labels:      labelLayer,
+		query:       query,
+		params:      params,
+		mvtQuery:    mvtQuery,
+		mvtParams:   mvtParams,
+		buffer:      buffer,
+		extent:      extent,
+		limit:       limit,
+		timeout:     time.Duration(lyr.Timeout),
+		generalize:  lyr.Generalize,
+		expanders:   sel.expanders,
+		precision:   lyr.Precision,
+		maxLength:   lyr.MaxLength,
+		cluster:     cluster,
+		bins:        bins,
+		labels:      labels,
+		merge:       merge,
+		optional:    lyr.Optional,
+		lowPriority: lowPriority,
+	}, nil
+}
+
+// tileGeometry returns the column as a 2D geometry for the tile: geography is cast to geometry, curves are linearized
+// and Z/M dropped
+func tileGeometry(column string, geography bool) string {
+	g := pgx.Identifier{column}.Sanitize()
+
+	if geography {
+		g += "::geometry"
 	}
 
-	sources := map[string][]*Layer{}
-	for _, source := range cfg.Sources {
-		sources[source.Name] = make([]*Layer, len(source.Layers))
+	return fmt.Sprintf("ST_Force2D(ST_CurveToLine(%s))", g)
+}
 
-		for idx, lyr := range source.Layers {
-			sources[source.Name][idx], err = read(db, source.Prefix, lyr, cfg.Schema)
+// intersects returns the spatial index filter of the column against the (possibly transformed) tile envelope
+func intersects(column, bbox string, geography bool) string {
+	if geography {
+		bbox += "::geography"
+	}
 
-			if err != nil {
-				return nil, err
-			}
+	return fmt.Sprintf("st_intersects(%s, %s)", pgx.Identifier{column}.Sanitize(), bbox)
+}
+
+// transform returns the tile envelope for the spatial index filter and the geometry read for the tile, for a table
+// stored in the given SRID. The box is transformed rather than the geometry so the spatial index can still be used.
+// Tables without an SRID (0) are assumed to be in the tile SRID.
+func transform(tileGeom string, srid int32) (string, string) {
+	if srid == tileSRID || srid == 0 {
+		return TokenBBox, tileGeom
+	}
+
+	return fmt.Sprintf("st_transform(%s, %d)", TokenBBox, srid), fmt.Sprintf("st_transform(%s, %d)", tileGeom, tileSRID)
+}
+
+// filterConditions builds the where conditions of the layer filters. Zoom filters only apply within their range, so
+// are switched on by the zoom bind parameter.
+func filterConditions(layer string, filters []config.Filter) ([]string, error) {
+	var conds []string
+
+	for _, f := range filters {
+		if strings.TrimSpace(f.Where) == "" {
+			return nil, fmt.Errorf("filter has no where condition: layer = %s", layer)
+		}
+
+		zooms := []string{}
+		if f.MinZoom != nil {
+			zooms = append(zooms, fmt.Sprintf("%s < %d", TokenZoom, *f.MinZoom))
+		}
+		if f.MaxZoom != nil {
+			zooms = append(zooms, fmt.Sprintf("%s > %d", TokenZoom, *f.MaxZoom))
+		}
+
+		if len(zooms) == 0 {
+			conds = append(conds, fmt.Sprintf("(%s)", f.Where))
+		} else {
+			conds = append(conds, fmt.Sprintf("(%s or (%s))", strings.Join(zooms, " or "), f.Where))
 		}
 	}
 
-	return &Db{*db, sources}, nil
+	return conds, nil
 }
 
-// Queries the specified layer to obtain metadata about the table.
-func read(db *pgx.ConnPool, prefix, layer, schema string) (*Layer, error) {
+// geometryColumns looks up the type and SRID of a geometry (false) or geography (true) column
+var geometryColumns = map[bool]string{
+	false: `
+		SELECT 
+			type, srid 
+		FROM 
+			geometry_columns 
+		WHERE f_table_schema = $1 
+		AND f_table_name = $2 
+		and f_geometry_column = $3;
+	`,
+	true: `
+		SELECT 
+			type, srid 
+		FROM 
+			geography_columns 
+		WHERE f_table_schema = $1 
+		AND f_table_name = $2 
+		and f_geography_column = $3;
+	`,
+}
+
+// tableColumns reads the columns of a table from the information schema
+func tableColumns(db *pgx.ConnPool, schema, table string) ([]column, error) {
 	rows, err := db.Query(`
 		select 
-			column_name, udt_name 
+			c.column_name::text, c.udt_name::text, coalesce(t.typcategory::text, '') 
 		from 
-			information_schema.columns 
+			information_schema.columns c 
+		left join 
+			pg_type t 
+		on 
+			t.typname = c.udt_name and t.typnamespace = (select oid from pg_namespace where nspname = c.udt_schema) 
 		where 
-			table_schema = $1 and table_name = $2 
+			c.table_schema = $1 and c.table_name = $2 
 		order by 
-			ordinal_position asc
-		`, schema, prefix+layer,
+			c.ordinal_position asc
+		`, schema, table,
 	)
 
 	if err != nil {
@@ -598,105 +1103,213 @@ func read(db *pgx.ConnPool, prefix, layer, schema string) (*Layer, error) {
 	}
 
 	defer rows.Close()
-	cols := []string{}
-	attrs := []Attribute{}
 
-	var col string
-	var udt string
-	var geom string
-	var columns string
-
-	count := 0
+	columns := []column{}
 	for rows.Next() {
-		err = rows.Scan(&col, &udt)
-
-		if err != nil {
+		var c column
+		if err = rows.Scan(&c.name, &c.udt, &c.category); err != nil {
 			return nil, err
 		}
+		columns = append(columns, c)
+	}
 
-		count++
+	return columns, rows.Err()
+}
 
-		// for the types supported, we stick to those that can be easily represented in JSON/MVT
-		// could possibly add further type support which can be converted to strings? e.g. URL, IP etc.
-		switch udt {
-		case "int2", "int4", "int8":
-			attrs = append(attrs, Attribute{col, "integer"})
-			cols = append(cols, col)
-		case "float4", "float8":
-			attrs = append(attrs, Attribute{col, "float"})
-			cols = append(cols, col)
-		case "bool":
-			attrs = append(attrs, Attribute{col, "boolean"})
-			cols = append(cols, col)
-		case "varchar", "text":
-			attrs = append(attrs, Attribute{col, "string"})
-			cols = append(cols, col)
-		case "geometry":
-			geom = col
-		}
-	}
-
-	if count == 0 {
-		return nil, fmt.Errorf("no data found for layer: name = %s", layer)
+// placeholder tile used when introspecting layer SQL
+var placeholder = &geo.BBox{Maxx: 1, Maxy: 1, Srid: 3857}
+
+// queryColumns introspects the result columns of layer SQL by running it (with placeholder token values) for no
+// rows. pgx only names the types it knows about, so the names are looked up by OID.
+func queryColumns(db *pgx.ConnPool, sql string) ([]column, error) {
+	query, params := bind(fmt.Sprintf("select * from (%s) q limit 0", sql))
+
+	rows, err := db.Query(query, args(params, placeholder, placeholder, 0, defaultExtent)...)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to run layer sql: err = %s", err)
 	}
 
-	err = rows.Err()
+	desc := rows.FieldDescriptions()
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to run layer sql: err = %s", err)
+	}
+
+	oids := make([]int32, len(desc))
+	for idx, fd := range desc {
+		oids[idx] = int32(fd.DataType)
+	}
+
+	rows, err = db.Query(`select oid::int4, typname::text, typcategory::text from pg_type where oid = any($1::int4[]::oid[])`, oids)
+
 	if err != nil {
 		return nil, err
 	}
 
-	if geom == "" {
-		return nil, fmt.Errorf("no geometry data found: layer = %s", layer)
+	defer rows.Close()
+
+	types := map[int32]column{}
+	for rows.Next() {
+		var oid int32
+		var c column
+		if err = rows.Scan(&oid, &c.udt, &c.category); err != nil {
+			return nil, err
+		}
+		types[oid] = c
 	}
 
-	if len(cols) > 0 {
-		columns = ", " + strings.Join(cols, ",")
+	if err = rows.Err(); err != nil {
+		return nil, err
 	}
 
-	// get the geometry type
-	var geomType string
-	err = db.QueryRow(`
-		SELECT 
-			type 
-		FROM 
-			geometry_columns 
-		WHERE f_table_schema = $1 
-		AND f_table_name = $2 
-		and f_geometry_column = $3;
-	`, schema, prefix+layer, geom).Scan(&geomType)
+	columns := make([]column, len(desc))
+	for idx, fd := range desc {
+		c := types[int32(fd.DataType)]
+		columns[idx] = column{name: fd.Name, udt: c.udt, category: c.category}
+	}
+
+	return columns, nil
+}
+
+// types which can be used as a feature id
+var idTypes = map[string]bool{
+	"int2":    true,
+	"int4":    true,
+	"int8":    true,
+	"varchar": true,
+	"text":    true,
+}
+
+// primaryKey looks up the primary key of a table, returning an empty string if there is no primary key or it spans
+// more than a single column.
+func primaryKey(db *pgx.ConnPool, schema, table string) (string, error) {
+	rows, err := db.Query(`
+		select 
+			kcu.column_name 
+		from 
+			information_schema.table_constraints tc 
+		join 
+			information_schema.key_column_usage kcu 
+		on 
+			tc.constraint_schema = kcu.constraint_schema and tc.constraint_name = kcu.constraint_name 
+		where 
+			tc.constraint_type = 'PRIMARY KEY' and tc.table_schema = $1 and tc.table_name = $2
+		`, schema, table,
+	)
 
 	if err != nil {
-		return nil, fmt.Errorf("cound not determine geometry data: layer = %s, err = %s", layer, err)
+		return "", err
 	}
 
-	attrs = append(attrs, Attribute{geom, geomType})
+	defer rows.Close()
 
-	return &Layer{
-		layer,
-		attrs,
-		fmt.Sprintf(
-			`select 
-				ST_AsBinary(ST_Intersection(%s, st_makeenvelope($1, $2, $3, $4, $5))) as geom %s 
-			from 
-				%s.%s%s 
-			where 
-				st_intersects(geometry, st_makeenvelope($1, $2, $3, $4, $5)) 
-			limit 
-				20000`,
-			geom, columns, schema, prefix, layer,
-		),
-	}, nil
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return "", err
+		}
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+
+	if len(keys) != 1 {
+		return "", nil
+	}
+
+	return keys[0], nil
 }
 
-// Attribute details a field value in a layer.
-type Attribute struct {
-	Name string `json:"name"`
-	Type string `json:"type"`
+// featureID converts an id column value to the unsigned MVT feature id. Strings are hashed (FNV-1a) and negative
+// integers cannot be represented so are ignored.
+func featureID(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case int16:
+		return uint64(v), v >= 0
+	case int32:
+		return uint64(v), v >= 0
+	case int64:
+		return uint64(v), v >= 0
+	case string:
+		h := fnv.New64a()
+		h.Write([]byte(v))
+		return h.Sum64(), true
+	}
+
+	return 0, false
+}
+
+// quoteLiteral quotes a string for use as a SQL literal
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
 }
 
 // Layer represents a database table
 type Layer struct {
-	Name       string      `json:"name"`
-	Attributes []Attribute `json:"attributes"`
-	query      string
+	Name        string      `json:"name"`
+	ID          string      `json:"id,omitempty"`
+	MinZoom     *int        `json:"minzoom,omitempty"`
+	MaxZoom     *int        `json:"maxzoom,omitempty"`
+	Attributes  []Attribute `json:"attributes"`
+	Labels      string      `json:"labels,omitempty"`
+	query       string
+	params      []param
+	mvtQuery    string
+	mvtParams   []param
+	buffer      uint32
+	extent      uint32
+	limit       int
+	timeout     time.Duration
+	generalize  *config.Generalize
+	expanders   map[string]*expander
+	precision   *int
+	maxLength   int
+	cluster     *clusterer
+	bins        *binner
+	labels      *labeller
+	merge       *merger
+	optional    bool
+	lowPriority map[string]bool
+}
+
+// Visible checks if the layer is served at the given zoom
+func (l *Layer) Visible(zoom int) bool {
+	if l.MinZoom != nil && zoom < *l.MinZoom {
+		return false
+	}
+
+	if l.MaxZoom != nil && zoom > *l.MaxZoom {
+		return false
+	}
+
+	return true
+}
+
+// margin is how far past the tile (in tile pixels) the layer is read at the zoom: the buffer, plus a cell for clustered
+// and binned layers so the cells on the edge of the tile see all of their points
+func (l *Layer) margin(zoom int) uint32 {
+	margin := l.buffer
+
+	if l.cluster.applies(zoom) {
+		margin += uint32(math.Ceil(l.cluster.radius))
+	}
+
+	if l.bins != nil {
+		margin += uint32(math.Ceil(l.bins.size))
+	}
+
+	return margin
+}
+
+// context applies the layer timeout (if any) to ctx
+func (l *Layer) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if l.timeout > 0 {
+		return context.WithTimeout(ctx, l.timeout)
+	}
+	return context.WithCancel(ctx)
 }
@@ -4,32 +4,131 @@ import (
 	"testing"
 
 	"github.com/devork/geom"
+	"github.com/devork/grava/config"
 	"github.com/devork/grava/geo"
 	"github.com/stretchr/testify/require"
 )
 
-// https://github.com/mapbox/vector-tile-spec/tree/master/2.1#4355-example-polygon
-func TestReadPolygon(t *testing.T) {
-	polygon := &geom.Polygon{
-		Hdr: geom.Hdr{Dim: geom.XY, Srid: 27700},
-		Rings: []geom.LinearRing{{
-			Coordinates: []geom.Coordinate{
-				{3, 6},
-				{8, 12},
-				{20, 34},
-				{3, 6},
-			},
-		}},
-	}
+func TestGrow(t *testing.T) {
+	// inverted 'y' as created by geo.NewBBox
+	box := &geo.BBox{Minx: 0, Miny: 1024, Maxx: 1024, Maxy: 0, Srid: 3857}
+
+	require.Equal(t, &geo.BBox{Minx: -16, Miny: 1040, Maxx: 1040, Maxy: -16, Srid: 3857}, grow(box, 64, 4096))
+
+	// the buffer is in pixels of the extent, so the same buffer covers more ground on a coarser tile
+	require.Equal(t, &geo.BBox{Minx: -128, Miny: 1152, Maxx: 1152, Maxy: -128, Srid: 3857}, grow(box, 64, 512))
+}
+
+func TestOrigin(t *testing.T) {
+	require.Equal(t, geom.Coordinate{0, 0}, origin(geo.NewBBox(0, 0, 0), 4096))
+	require.Equal(t, geom.Coordinate{3 * 4096, 5 * 4096}, origin(geo.NewBBox(3, 5, 4), 4096))
+	require.Equal(t, geom.Coordinate{2000 * 512, 1000 * 512}, origin(geo.NewBBox(2000, 1000, 12), 512))
+}
+
+func TestNames(t *testing.T) {
+	layers := []*Layer{{Name: "building"}, {Name: "road"}, {Name: "water"}}
+
+	require.Nil(t, names(layers, []bool{false, false, false}))
+	require.Equal(t, []string{"building", "water"}, names(layers, []bool{true, false, true}))
+}
+
+func TestMembers(t *testing.T) {
+	point := &geom.Point{Coordinate: geom.Coordinate{1, 2}}
+	line := &geom.LineString{Coordinates: []geom.Coordinate{{0, 0}, {1, 1}}}
+	polygon := &geom.Polygon{Rings: []geom.LinearRing{{Coordinates: []geom.Coordinate{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}}}
+
+	require.Equal(t, []geom.Geometry{point}, members(point))
+
+	gc := &geom.GeometryCollection{Geometries: []geom.Geometry{
+		point,
+		&geom.GeometryCollection{Geometries: []geom.Geometry{line, polygon}},
+		&geom.GeometryCollection{},
+	}}
 
-	feature := readPolygon(polygon, &geo.BBox{}, 1.0, 1.0)
-	require.NotNil(t, feature)
+	require.Equal(t, []geom.Geometry{point, line, polygon}, members(gc))
+}
+
+func TestVisible(t *testing.T) {
+	five, ten := 5, 10
 
-	t.Logf("%+v", feature.Geometry)
-	require.Equal(t, 9, len(feature.Geometry))
+	tests := []struct {
+		layer   *Layer
+		zoom    int
+		visible bool
+	}{
+		{&Layer{}, 0, true},
+		{&Layer{}, 22, true},
+		{&Layer{MinZoom: &five, MaxZoom: &ten}, 4, false},
+		{&Layer{MinZoom: &five, MaxZoom: &ten}, 5, true},
+		{&Layer{MinZoom: &five, MaxZoom: &ten}, 10, true},
+		{&Layer{MinZoom: &five, MaxZoom: &ten}, 11, false},
+		{&Layer{MinZoom: &five}, 4, false},
+		{&Layer{MinZoom: &five}, 22, true},
+		{&Layer{MaxZoom: &ten}, 0, true},
+		{&Layer{MaxZoom: &ten}, 11, false},
+	}
 
-	var expected = []uint32{9, 6, 12, 18, 10, 12, 24, 44, 15}
-	for idx, cmd := range feature.Geometry {
-		require.Equal(t, expected[idx], cmd)
+	for _, tt := range tests {
+		require.Equal(t, tt.visible, tt.layer.Visible(tt.zoom), "minzoom = %v, maxzoom = %v, zoom = %d",
+			tt.layer.MinZoom, tt.layer.MaxZoom, tt.zoom)
 	}
 }
+
+func TestFilterConditions(t *testing.T) {
+	five, ten := 5, 10
+
+	conds, err := filterConditions("road", []config.Filter{
+		{Where: "class = 'motorway'"},
+		{MinZoom: &five, MaxZoom: &ten, Where: "class <> 'path'"},
+		{MinZoom: &five, Where: "length > 10"},
+		{MaxZoom: &ten, Where: "bridge"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"(class = 'motorway')",
+		"(!ZOOM! < 5 or !ZOOM! > 10 or (class <> 'path'))",
+		"(!ZOOM! < 5 or (length > 10))",
+		"(!ZOOM! > 10 or (bridge))",
+	}, conds)
+
+	conds, err = filterConditions("road", nil)
+	require.NoError(t, err)
+	require.Empty(t, conds)
+
+	_, err = filterConditions("road", []config.Filter{{MinZoom: &five, Where: " "}})
+	require.EqualError(t, err, "filter has no where condition: layer = road")
+}
+
+func TestTransform(t *testing.T) {
+	bbox, g := transform(`"geom"`, 3857)
+	require.Equal(t, "!BBOX!", bbox)
+	require.Equal(t, `"geom"`, g)
+
+	// no SRID is taken to be the tile SRID
+	bbox, g = transform(`"geom"`, 0)
+	require.Equal(t, "!BBOX!", bbox)
+	require.Equal(t, `"geom"`, g)
+
+	bbox, g = transform(`"geom"`, 27700)
+	require.Equal(t, "st_transform(!BBOX!, 27700)", bbox)
+	require.Equal(t, `st_transform("geom", 3857)`, g)
+}
+
+func TestTileGeometry(t *testing.T) {
+	require.Equal(t, `ST_Force2D(ST_CurveToLine("geom"))`, tileGeometry("geom", false))
+	require.Equal(t, `ST_Force2D(ST_CurveToLine("geog"::geometry))`, tileGeometry("geog", true))
+}
+
+func TestIntersects(t *testing.T) {
+	require.Equal(t, `st_intersects("geom", !BBOX!)`, intersects("geom", TokenBBox, false))
+	require.Equal(t, `st_intersects("geog", st_transform(!BBOX!, 4326)::geography)`,
+		intersects("geog", "st_transform(!BBOX!, 4326)", true))
+}
+
+func TestGeometryColumns(t *testing.T) {
+	require.Contains(t, geometryColumns[false], "geometry_columns")
+	require.Contains(t, geometryColumns[false], "f_geometry_column = $3")
+
+	require.Contains(t, geometryColumns[true], "geography_columns")
+	require.Contains(t, geometryColumns[true], "f_geography_column = $3")
+}
@@ -0,0 +1,108 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/devork/grava/config"
+)
+
+// expansions holds the SQL (with %[1]s being the column) reading each expandable type as a json object
+var expansions = map[string]string{
+	"json":   "%[1]s::text",
+	"jsonb":  "%[1]s::text",
+	"hstore": "hstore_to_json(%[1]s)::text",
+}
+
+// property is a single key/value of an expanded column
+type property struct {
+	key   string
+	value interface{}
+}
+
+// expander flattens the top level keys of a json object into properties
+type expander struct {
+	prefix string
+	allow  map[string]bool
+	deny   map[string]bool
+}
+
+// newExpander creates the expander for the configured column
+func newExpander(cfg config.Expand) *expander {
+	e := &expander{prefix: cfg.Prefix, deny: map[string]bool{}}
+
+	if len(cfg.Allow) > 0 {
+		e.allow = map[string]bool{}
+		for _, key := range cfg.Allow {
+			e.allow[key] = true
+		}
+	}
+
+	for _, key := range cfg.Deny {
+		e.deny[key] = true
+	}
+
+	return e
+}
+
+// expand returns the allowed properties of the json object, sorted by key. Numbers are read as integers where they
+// can be, nested objects and arrays are kept as json strings and null values are dropped.
+func (e *expander) expand(text string) ([]property, error) {
+	d := json.NewDecoder(bytes.NewReader([]byte(text)))
+	d.UseNumber()
+
+	var obj map[string]json.RawMessage
+	if err := d.Decode(&obj); err != nil {
+		return nil, err
+	}
+
+	props := make([]property, 0, len(obj))
+
+	for key, raw := range obj {
+		if (e.allow != nil && !e.allow[key]) || e.deny[key] {
+			continue
+		}
+
+		value, err := e.value(raw)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if value != nil {
+			props = append(props, property{e.prefix + key, value})
+		}
+	}
+
+	sort.Slice(props, func(i, j int) bool { return props[i].key < props[j].key })
+
+	return props, nil
+}
+
+func (e *expander) value(raw json.RawMessage) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case map[string]interface{}, []interface{}:
+		var b bytes.Buffer
+		if err := json.Compact(&b, raw); err != nil {
+			return nil, err
+		}
+		return b.String(), nil
+	}
+
+	// strings, booleans and nil
+	return v, nil
+}
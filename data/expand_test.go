@@ -0,0 +1,35 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/devork/grava/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpand(t *testing.T) {
+	e := newExpander(config.Expand{Column: "tags", Prefix: "tag:", Deny: []string{"fixme"}})
+
+	props, err := e.expand(`{"name": "Mill", "levels": 3, "height": 12.5, "listed": true, "fixme": "check", "note": null, "addr": {"street": "High St", "number": [1, 2]}}`)
+
+	require.NoError(t, err)
+	require.Equal(t, []property{
+		{"tag:addr", `{"street":"High St","number":[1,2]}`},
+		{"tag:height", 12.5},
+		{"tag:levels", int64(3)},
+		{"tag:listed", true},
+		{"tag:name", "Mill"},
+	}, props)
+}
+
+func TestExpandAllow(t *testing.T) {
+	e := newExpander(config.Expand{Column: "tags", Allow: []string{"name", "levels"}, Deny: []string{"levels"}})
+
+	props, err := e.expand(`{"name": "Mill", "levels": "3", "height": "12"}`)
+
+	require.NoError(t, err)
+	require.Equal(t, []property{{"name", "Mill"}}, props)
+
+	_, err = e.expand(`[1, 2]`)
+	require.Error(t, err)
+}
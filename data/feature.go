@@ -0,0 +1,106 @@
+package data
+
+import (
+	"github.com/devork/geom"
+	"github.com/devork/grava/vtile"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// feature is a feature of a layer read into tile space (projected and clipped) but not yet encoded, so it can still be
+// reworked as a whole layer, e.g. clustered
+type feature struct {
+	geom  geom.Geometry
+	id    *uint64
+	props []property
+}
+
+// prop returns the value of the named property, nil if the feature doesn't have it
+func (f *feature) prop(key string) interface{} {
+	for _, p := range f.props {
+		if p.key == key {
+			return p.value
+		}
+	}
+	return nil
+}
+
+// content is a tile layer read from a layer of the source (either the layer itself or its label layer), not yet
+// encoded. Dropped contents are left out of the tile.
+type content struct {
+	lyr      *Layer
+	name     string
+	features []*feature
+	dropped  bool
+}
+
+// encode builds the tile from its layers. With debug logging on, each layer is checked as a whole (tags as well as
+// geometries) against the specification.
+func encode(contents []*content) *vtile.Tile {
+	debug := log.IsLevelEnabled(log.DebugLevel)
+
+	tile := &vtile.Tile{Layers: make([]*vtile.Tile_Layer, 0, len(contents))}
+	for _, c := range contents {
+		if c.dropped {
+			continue
+		}
+
+		layer := c.lyr.encode(c.name, c.features)
+		if debug {
+			if err := vtile.ValidateLayer(layer); err != nil {
+				log.Warnf("invalid tile layer: layer = %s, error = %s", c.name, err)
+			}
+		}
+
+		tile.Layers = append(tile.Layers, layer)
+	}
+	return tile
+}
+
+// encode builds the tile layer of the given name from the features. Features left with no geometry once quantized
+// are dropped, as are features which don't encode to a valid geometry.
+func (l *Layer) encode(name string, features []*feature) *vtile.Tile_Layer {
+	// geometries are already in tile space
+	enc := vtile.NewEncoder(vtile.Identity)
+
+	dict := vtile.NewDictionary()
+	dict.Precision = l.precision
+	dict.MaxLength = l.maxLength
+
+	tileLayer := &vtile.Tile_Layer{
+		Version:  &mvtVersion,
+		Name:     &name,
+		Extent:   &l.extent,
+		Features: make([]*vtile.Tile_Feature, 0, len(features)),
+	}
+
+	for _, f := range features {
+		feature, err := enc.Encode(f.geom)
+
+		if err == vtile.ErrEmptyGeometry {
+			// nothing left after quantization
+			continue
+		}
+
+		if err == nil {
+			err = vtile.Validate(feature)
+		}
+
+		if err != nil {
+			log.Warnf("failed to encode geometry: layer = %s, geometry = %v, error = %s", name, f.geom.Type(), err)
+			continue
+		}
+
+		feature.Id = f.id
+		for _, p := range f.props {
+			feature.Tags = dict.Tag(feature.Tags, p.key, p.value)
+		}
+
+		tileLayer.Features = append(tileLayer.Features, feature)
+	}
+
+	tileLayer.Keys = dict.Keys()
+	tileLayer.Values = dict.Values()
+
+	return tileLayer
+}
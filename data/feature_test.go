@@ -0,0 +1,67 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/devork/geom"
+	"github.com/devork/grava/vtile"
+	"github.com/stretchr/testify/require"
+)
+
+// The helpers below build the tile space features and geometries shared by the tests of the package.
+
+func point(x, y float64, props ...property) *feature {
+	return &feature{geom: &geom.Point{Coordinate: geom.Coordinate{x, y}}, props: props}
+}
+
+func line(cs []geom.Coordinate, props ...property) *feature {
+	return &feature{geom: &geom.LineString{Coordinates: cs}, props: props}
+}
+
+// wiggle is a line of many points a pixel either side of y
+func wiggle(y, length float64, props ...property) *feature {
+	var cs []geom.Coordinate
+	for x := 0.0; x <= length; x += 4 {
+		cs = append(cs, geom.Coordinate{x, y + float64(int(x/4)%2)})
+	}
+	return line(cs, props...)
+}
+
+func polygon(rings ...[]geom.Coordinate) *geom.Polygon {
+	p := &geom.Polygon{}
+	for _, ring := range rings {
+		p.Rings = append(p.Rings, geom.LinearRing{Coordinates: ring})
+	}
+	return p
+}
+
+func square(x, y, size float64) geom.Polygon {
+	return *polygon([]geom.Coordinate{{x, y}, {x + size, y}, {x + size, y + size}, {x, y + size}, {x, y}})
+}
+
+func TestEncode(t *testing.T) {
+	lyr := &Layer{Name: "road", extent: 4096}
+	id := uint64(7)
+
+	roads := []*feature{
+		line([]geom.Coordinate{{0, 0}, {10, 0}}, property{"class", "A Road"}, property{"width", 7.5}),
+		line([]geom.Coordinate{{0, 5}, {10, 5}}, property{"class", "A Road"}, property{"lanes", int64(2)}),
+		{geom: polygon([]geom.Coordinate{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}), id: &id},
+		// nothing left once quantized
+		line([]geom.Coordinate{{1, 1}, {1.2, 1.2}}),
+	}
+
+	tile := encode([]*content{
+		{lyr: lyr, name: "road", features: roads},
+		{lyr: lyr, name: "road_label", features: []*feature{point(5, 5)}, dropped: true},
+	})
+
+	require.Len(t, tile.Layers, 1)
+
+	layer := tile.Layers[0]
+	require.NoError(t, vtile.ValidateLayer(layer))
+	require.Len(t, layer.Features, 3)
+	require.Equal(t, []string{"class", "width", "lanes"}, layer.Keys)
+	require.Equal(t, []uint32{0, 0, 2, 2}, layer.Features[1].Tags)
+	require.Equal(t, uint64(7), layer.Features[2].GetId())
+}
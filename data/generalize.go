@@ -0,0 +1,228 @@
+package data
+
+import (
+	"math"
+
+	"github.com/devork/geom"
+	"github.com/devork/grava/config"
+)
+
+// generalizer simplifies tile space geometries. It runs on the projected (but unclipped) geometry so that the same
+// feature is simplified the same way in neighbouring tiles.
+type generalizer struct {
+	tolerance float64
+	grid      float64
+	minArea   float64
+	minLength float64
+}
+
+// newGeneralizer creates the generalizer for the given zoom, returning nil if there is nothing to do
+func newGeneralizer(cfg *config.Generalize, zoom int) *generalizer {
+	if cfg == nil || (cfg.MaxZoom != nil && zoom > *cfg.MaxZoom) {
+		return nil
+	}
+
+	return &generalizer{
+		tolerance: cfg.Tolerance,
+		grid:      cfg.Grid,
+		minArea:   cfg.MinArea,
+		minLength: cfg.MinLength,
+	}
+}
+
+// generalize returns the simplified geometry, or nil if the geometry is too small to keep. Members of multi
+// geometries are dropped individually.
+func (gz *generalizer) generalize(g geom.Geometry) geom.Geometry {
+	switch g := g.(type) {
+	case *geom.Point:
+		return &geom.Point{Hdr: g.Hdr, Coordinate: gz.snap(g.Coordinate)}
+
+	case *geom.MultiPoint:
+		out := &geom.MultiPoint{Hdr: g.Hdr, Points: make([]geom.Point, len(g.Points))}
+		for idx, p := range g.Points {
+			out.Points[idx] = geom.Point{Hdr: p.Hdr, Coordinate: gz.snap(p.Coordinate)}
+		}
+		return out
+
+	case *geom.LineString:
+		cs := gz.line(g.Coordinates)
+		if cs == nil {
+			return nil
+		}
+		return &geom.LineString{Hdr: g.Hdr, Coordinates: cs}
+
+	case *geom.MultiLineString:
+		lines := []geom.LineString{}
+		for _, ls := range g.LineStrings {
+			if cs := gz.line(ls.Coordinates); cs != nil {
+				lines = append(lines, geom.LineString{Hdr: ls.Hdr, Coordinates: cs})
+			}
+		}
+
+		if len(lines) == 0 {
+			return nil
+		}
+		return &geom.MultiLineString{Hdr: g.Hdr, LineStrings: lines}
+
+	case *geom.Polygon:
+		p, ok := gz.polygon(g)
+		if !ok {
+			return nil
+		}
+		return p
+
+	case *geom.MultiPolygon:
+		polygons := []geom.Polygon{}
+		for idx := range g.Polygons {
+			if p, ok := gz.polygon(&g.Polygons[idx]); ok {
+				polygons = append(polygons, *p)
+			}
+		}
+
+		if len(polygons) == 0 {
+			return nil
+		}
+		return &geom.MultiPolygon{Hdr: g.Hdr, Polygons: polygons}
+	}
+
+	return g
+}
+
+func (gz *generalizer) line(cs []geom.Coordinate) []geom.Coordinate {
+	if gz.minLength > 0 && length(cs) < gz.minLength {
+		return nil
+	}
+
+	cs = dedupe(gz.snaps(simplify(cs, gz.tolerance)))
+
+	if len(cs) < 2 {
+		return nil
+	}
+
+	return cs
+}
+
+func (gz *generalizer) polygon(p *geom.Polygon) (*geom.Polygon, bool) {
+	if len(p.Rings) == 0 {
+		return nil, false
+	}
+
+	out := &geom.Polygon{Hdr: p.Hdr}
+
+	for idx, ring := range p.Rings {
+		cs := ring.Coordinates
+
+		// holes below the minimum area are dropped as well as exteriors
+		if gz.minArea > 0 && math.Abs(area(cs)/2) < gz.minArea {
+			if idx == 0 {
+				return nil, false
+			}
+			continue
+		}
+
+		cs = dedupe(gz.snaps(simplify(cs, gz.tolerance)))
+
+		if len(cs) < 4 || area(cs) == 0 {
+			if idx == 0 {
+				return nil, false
+			}
+			continue
+		}
+
+		out.Rings = append(out.Rings, geom.LinearRing{Coordinates: cs})
+	}
+
+	return out, true
+}
+
+func (gz *generalizer) snap(c geom.Coordinate) geom.Coordinate {
+	if gz.grid <= 0 {
+		return c
+	}
+	return geom.Coordinate{round(c[0]/gz.grid) * gz.grid, round(c[1]/gz.grid) * gz.grid}
+}
+
+func (gz *generalizer) snaps(cs []geom.Coordinate) []geom.Coordinate {
+	if gz.grid <= 0 {
+		return cs
+	}
+
+	out := make([]geom.Coordinate, len(cs))
+	for idx, c := range cs {
+		out[idx] = gz.snap(c)
+	}
+	return out
+}
+
+// simplify runs Douglas-Peucker over the coordinates, always keeping the first and last coordinate (so closed rings
+// stay closed)
+func simplify(cs []geom.Coordinate, tolerance float64) []geom.Coordinate {
+	if tolerance <= 0 || len(cs) < 3 {
+		return cs
+	}
+
+	keep := make([]bool, len(cs))
+	keep[0] = true
+	keep[len(cs)-1] = true
+
+	tol2 := tolerance * tolerance
+	stack := [][2]int{{0, len(cs) - 1}}
+
+	for len(stack) > 0 {
+		span := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		first, last := span[0], span[1]
+		max := 0.0
+		index := -1
+
+		for idx := first + 1; idx < last; idx++ {
+			if d := segmentDistance2(cs[idx], cs[first], cs[last]); d > max {
+				max = d
+				index = idx
+			}
+		}
+
+		if index != -1 && max > tol2 {
+			keep[index] = true
+			stack = append(stack, [2]int{first, index}, [2]int{index, last})
+		}
+	}
+
+	out := make([]geom.Coordinate, 0, len(cs))
+	for idx, c := range cs {
+		if keep[idx] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// segmentDistance2 returns the squared distance of p from the segment a -> b
+func segmentDistance2(p, a, b geom.Coordinate) float64 {
+	x, y := a[0], a[1]
+	dx, dy := b[0]-x, b[1]-y
+
+	if dx != 0 || dy != 0 {
+		t := ((p[0]-x)*dx + (p[1]-y)*dy) / (dx*dx + dy*dy)
+
+		if t > 1 {
+			x, y = b[0], b[1]
+		} else if t > 0 {
+			x += dx * t
+			y += dy * t
+		}
+	}
+
+	dx, dy = p[0]-x, p[1]-y
+	return dx*dx + dy*dy
+}
+
+// length returns the length of the line
+func length(cs []geom.Coordinate) float64 {
+	var sum float64
+	for idx := 1; idx < len(cs); idx++ {
+		sum += math.Hypot(cs[idx][0]-cs[idx-1][0], cs[idx][1]-cs[idx-1][1])
+	}
+	return sum
+}
@@ -0,0 +1,55 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/devork/geom"
+	"github.com/devork/grava/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimplify(t *testing.T) {
+	cs := []geom.Coordinate{{0, 0}, {5, 1}, {10, 0}, {15, 8}, {20, 0}}
+
+	require.Equal(t, cs, simplify(cs, 0))
+	require.Equal(t, []geom.Coordinate{{0, 0}, {10, 0}, {15, 8}, {20, 0}}, simplify(cs, 2))
+	require.Equal(t, []geom.Coordinate{{0, 0}, {20, 0}}, simplify(cs, 10))
+}
+
+func TestGeneralizeMaxZoom(t *testing.T) {
+	z := 12
+	cfg := &config.Generalize{MaxZoom: &z, Tolerance: 1}
+
+	require.NotNil(t, newGeneralizer(cfg, 12))
+	require.Nil(t, newGeneralizer(cfg, 13))
+	require.Nil(t, newGeneralizer(nil, 0))
+}
+
+func TestGeneralizeMinArea(t *testing.T) {
+	square := func(x, y, size float64) geom.Polygon {
+		return geom.Polygon{
+			Rings: []geom.LinearRing{{
+				Coordinates: []geom.Coordinate{{x, y}, {x + size, y}, {x + size, y + size}, {x, y + size}, {x, y}},
+			}},
+		}
+	}
+
+	gz := newGeneralizer(&config.Generalize{MinArea: 16}, 0)
+
+	mp := &geom.MultiPolygon{Polygons: []geom.Polygon{square(0, 0, 2), square(10, 10, 8)}}
+	g := gz.generalize(mp)
+	require.NotNil(t, g)
+	require.Equal(t, 1, len(g.(*geom.MultiPolygon).Polygons))
+
+	small := square(0, 0, 3)
+	require.Nil(t, gz.generalize(&small))
+}
+
+func TestGeneralizeMinLength(t *testing.T) {
+	gz := newGeneralizer(&config.Generalize{MinLength: 10, Grid: 4}, 0)
+
+	require.Nil(t, gz.generalize(&geom.LineString{Coordinates: []geom.Coordinate{{0, 0}, {3, 4}}}))
+
+	g := gz.generalize(&geom.LineString{Coordinates: []geom.Coordinate{{1, 1}, {9, 1}, {15, 1}}})
+	require.Equal(t, []geom.Coordinate{{0, 0}, {8, 0}, {16, 0}}, g.(*geom.LineString).Coordinates)
+}
@@ -0,0 +1,280 @@
+package data
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/devork/geom"
+	"github.com/devork/grava/config"
+)
+
+// Label point methods, see config.Labels
+const (
+	LabelInaccessibility = "inaccessibility"
+	LabelSurface         = "surface"
+)
+
+// labelProbes limits the cells probed for the pole of inaccessibility of a polygon, each probe measuring the distance
+// to every edge of the polygon: large polygons are labelled less precisely rather than slowly
+var labelProbes = 256
+
+// worldSize is the width of the (EPSG:3857) world in ground units
+const worldSize = 2 * 20037508.342789244
+
+// labeller places a label point in each polygon of a layer. Points are found from the full geometry in ground units,
+// before it is projected and clipped, so every tile finds the same point for a polygon.
+type labeller struct {
+	layer  string
+	method string
+}
+
+// newLabeller validates the labels configuration of a layer, returning nil if the layer has no labels
+func newLabeller(layer string, cfg *config.Labels) (*labeller, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	method := cfg.Method
+	if method == "" {
+		method = LabelInaccessibility
+	}
+
+	if method != LabelInaccessibility && method != LabelSurface {
+		return nil, fmt.Errorf("unknown label method: layer = %s, method = %s", layer, method)
+	}
+
+	name := cfg.Layer
+	if name == "" {
+		name = layer + "_label"
+	}
+
+	return &labeller{layer: name, method: method}, nil
+}
+
+// label returns the label point of a polygon or multi polygon (the largest polygon of which is labelled), nil for any
+// other geometry. The pole of inaccessibility is found to within the given precision (in ground units), or as near as
+// labelProbes allows.
+func (lb *labeller) label(g geom.Geometry, precision float64) *geom.Point {
+	var p *geom.Polygon
+
+	switch g := g.(type) {
+	case *geom.Polygon:
+		p = g
+	case *geom.MultiPolygon:
+		largest := -1.0
+		for idx := range g.Polygons {
+			if len(g.Polygons[idx].Rings) == 0 {
+				continue
+			}
+
+			if a := math.Abs(area(g.Polygons[idx].Rings[0].Coordinates)); a > largest {
+				largest = a
+				p = &g.Polygons[idx]
+			}
+		}
+	}
+
+	if p == nil || len(p.Rings) == 0 || len(p.Rings[0].Coordinates) == 0 {
+		return nil
+	}
+
+	var c geom.Coordinate
+	if lb.method == LabelSurface {
+		c = surface(p)
+	} else {
+		c, _ = inaccessibility(p, precision, labelProbes)
+	}
+
+	return &geom.Point{Hdr: p.Hdr, Coordinate: c}
+}
+
+// surface finds a point on the surface of the polygon: the middle of the widest span across the polygon on the line
+// half way up its bounds
+func surface(p *geom.Polygon) geom.Coordinate {
+	minx, miny, maxx, maxy := bounds(p.Rings[0].Coordinates)
+	y := (miny + maxy) / 2
+
+	var xs []float64
+	for _, ring := range p.Rings {
+		cs := ring.Coordinates
+		for idx := 1; idx < len(cs); idx++ {
+			a, b := cs[idx-1], cs[idx]
+
+			// half open, so a vertex on the line is only counted once
+			if (a[1] <= y) != (b[1] <= y) {
+				xs = append(xs, a[0]+(y-a[1])*(b[0]-a[0])/(b[1]-a[1]))
+			}
+		}
+	}
+
+	sort.Float64s(xs)
+
+	best, width := geom.Coordinate{(minx + maxx) / 2, y}, -1.0
+	for idx := 1; idx < len(xs); idx += 2 {
+		if w := xs[idx] - xs[idx-1]; w > width {
+			width = w
+			best = geom.Coordinate{(xs[idx] + xs[idx-1]) / 2, y}
+		}
+	}
+
+	if width < 0 {
+		// no area, e.g. a flat polygon
+		return p.Rings[0].Coordinates[0]
+	}
+
+	return best
+}
+
+// labelCell is a square of the polygon's bounds searched for the pole of inaccessibility
+type labelCell struct {
+	x, y float64
+	h    float64 // half the cell size
+	d    float64 // distance from the cell centre to the polygon (negative outside)
+	max  float64 // maximum distance to the polygon within the cell
+}
+
+func newLabelCell(x, y, h float64, p *geom.Polygon) *labelCell {
+	d := distance(x, y, p)
+	return &labelCell{x: x, y: y, h: h, d: d, max: d + h*math.Sqrt2}
+}
+
+// labelCells is a max heap of cells, by the distance that can be found within the cell
+type labelCells []*labelCell
+
+func (q labelCells) Len() int            { return len(q) }
+func (q labelCells) Less(i, j int) bool  { return q[i].max > q[j].max }
+func (q labelCells) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *labelCells) Push(x interface{}) { *q = append(*q, x.(*labelCell)) }
+
+func (q *labelCells) Pop() interface{} {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// inaccessibility finds the pole of inaccessibility (the point inside the polygon furthest from its outline) to within
+// the precision, using the polylabel algorithm: cells which can't hold a better point than the best found so far are
+// discarded, others are split into four until they are smaller than the precision. The search stops early once the
+// given number of cells have been probed. The point is returned with the number of cells probed.
+func inaccessibility(p *geom.Polygon, precision float64, probes int) (geom.Coordinate, int) {
+	minx, miny, maxx, maxy := bounds(p.Rings[0].Coordinates)
+	w, h := maxx-minx, maxy-miny
+
+	if w <= 0 || h <= 0 {
+		return geom.Coordinate{minx, miny}, 0
+	}
+
+	n := 0
+	probe := func(x, y, h float64) *labelCell {
+		n++
+		return newLabelCell(x, y, h, p)
+	}
+
+	// the polygon is covered with square cells, fewer (and so larger) than the probes for long thin polygons
+	size := math.Max(math.Min(w, h), math.Max(w, h)/math.Sqrt(float64(probes)/4))
+
+	q := &labelCells{}
+	for x := minx; x < maxx; x += size {
+		for y := miny; y < maxy; y += size {
+			heap.Push(q, probe(x+size/2, y+size/2, size/2))
+		}
+	}
+
+	// start with the centroid, then the centre of the bounds
+	var best *labelCell
+	if cx, cy, ok := centroid(p.Rings[0].Coordinates); ok {
+		best = probe(cx, cy, 0)
+	} else {
+		best = probe(p.Rings[0].Coordinates[0][0], p.Rings[0].Coordinates[0][1], 0)
+	}
+
+	if c := probe((minx+maxx)/2, (miny+maxy)/2, 0); c.d > best.d {
+		best = c
+	}
+
+	for q.Len() > 0 && n+4 <= probes {
+		c := heap.Pop(q).(*labelCell)
+
+		if c.d > best.d {
+			best = c
+		}
+
+		if c.max-best.d <= precision {
+			continue
+		}
+
+		h := c.h / 2
+		heap.Push(q, probe(c.x-h, c.y-h, h))
+		heap.Push(q, probe(c.x+h, c.y-h, h))
+		heap.Push(q, probe(c.x-h, c.y+h, h))
+		heap.Push(q, probe(c.x+h, c.y+h, h))
+	}
+
+	// the probes may have run out with cells still queued, which have been measured
+	for _, c := range *q {
+		if c.d > best.d {
+			best = c
+		}
+	}
+
+	return geom.Coordinate{best.x, best.y}, n
+}
+
+// distance returns the distance from the point to the outline of the polygon, negative if the point is outside
+func distance(x, y float64, p *geom.Polygon) float64 {
+	inside := false
+	min := math.Inf(1)
+
+	for _, ring := range p.Rings {
+		cs := ring.Coordinates
+		for idx := 1; idx < len(cs); idx++ {
+			a, b := cs[idx-1], cs[idx]
+
+			if (a[1] > y) != (b[1] > y) && x < (b[0]-a[0])*(y-a[1])/(b[1]-a[1])+a[0] {
+				inside = !inside
+			}
+
+			min = math.Min(min, segmentDistance2(geom.Coordinate{x, y}, a, b))
+		}
+	}
+
+	d := math.Sqrt(min)
+	if !inside {
+		return -d
+	}
+	return d
+}
+
+// centroid returns the centre of mass of the ring, if it has an area
+func centroid(cs []geom.Coordinate) (float64, float64, bool) {
+	var x, y, a float64
+
+	for idx := 1; idx < len(cs); idx++ {
+		p, q := cs[idx-1], cs[idx]
+		f := p[0]*q[1] - q[0]*p[1]
+		x += (p[0] + q[0]) * f
+		y += (p[1] + q[1]) * f
+		a += f * 3
+	}
+
+	if a == 0 {
+		return 0, 0, false
+	}
+
+	return x / a, y / a, true
+}
+
+func bounds(cs []geom.Coordinate) (minx, miny, maxx, maxy float64) {
+	minx, miny = math.Inf(1), math.Inf(1)
+	maxx, maxy = math.Inf(-1), math.Inf(-1)
+
+	for _, c := range cs {
+		minx, maxx = math.Min(minx, c[0]), math.Max(maxx, c[0])
+		miny, maxy = math.Min(miny, c[1]), math.Max(maxy, c[1])
+	}
+
+	return minx, miny, maxx, maxy
+}
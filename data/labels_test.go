@@ -0,0 +1,99 @@
+package data
+
+import (
+	"math"
+	"testing"
+
+	"github.com/devork/geom"
+	"github.com/devork/grava/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLabeller(t *testing.T) {
+	lb, err := newLabeller("building", nil)
+	require.NoError(t, err)
+	require.Nil(t, lb)
+
+	lb, err = newLabeller("building", &config.Labels{})
+	require.NoError(t, err)
+	require.Equal(t, &labeller{layer: "building_label", method: LabelInaccessibility}, lb)
+
+	lb, err = newLabeller("building", &config.Labels{Layer: "names", Method: LabelSurface})
+	require.NoError(t, err)
+	require.Equal(t, &labeller{layer: "names", method: LabelSurface}, lb)
+
+	_, err = newLabeller("building", &config.Labels{Method: "centroid"})
+	require.Error(t, err)
+}
+
+func TestLabelInaccessibility(t *testing.T) {
+	lb := &labeller{method: LabelInaccessibility}
+
+	square := polygon([]geom.Coordinate{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}})
+	p := lb.label(square, 0.01)
+	require.InDelta(t, 5, p.Coordinate[0], 0.01)
+	require.InDelta(t, 5, p.Coordinate[1], 0.01)
+
+	// the centroid of a U shape is outside of it, the label is in the thickest part
+	u := polygon([]geom.Coordinate{{0, 0}, {30, 0}, {30, 30}, {20, 30}, {20, 10}, {10, 10}, {10, 30}, {0, 30}, {0, 0}})
+	p = lb.label(u, 0.01)
+	require.True(t, distance(p.Coordinate[0], p.Coordinate[1], u) >= 4.99, "%v", p.Coordinate)
+
+	// holes are avoided
+	holed := polygon(
+		[]geom.Coordinate{{0, 0}, {30, 0}, {30, 30}, {0, 30}, {0, 0}},
+		[]geom.Coordinate{{5, 5}, {25, 5}, {25, 25}, {5, 25}, {5, 5}},
+	)
+	p = lb.label(holed, 0.01)
+	require.True(t, distance(p.Coordinate[0], p.Coordinate[1], holed) > 2, "%v", p.Coordinate)
+
+	// multi polygons are labelled in their largest polygon
+	mp := &geom.MultiPolygon{Polygons: []geom.Polygon{
+		*polygon([]geom.Coordinate{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}),
+		*square,
+	}}
+	p = lb.label(mp, 0.01)
+	require.InDelta(t, 5, p.Coordinate[0], 0.01)
+
+	require.Nil(t, lb.label(&geom.LineString{Coordinates: []geom.Coordinate{{0, 0}, {1, 1}}}, 0.01))
+}
+
+func TestInaccessibilityProbes(t *testing.T) {
+	// a lake of many vertices, with no limit on the precision
+	var cs []geom.Coordinate
+	for idx := 0; idx < 100000; idx++ {
+		a := 2 * math.Pi * float64(idx) / 100000
+		cs = append(cs, geom.Coordinate{1000 + 500*math.Cos(a), 2000 + 500*math.Sin(a)})
+	}
+	lake := polygon(append(cs, cs[0]))
+
+	c, n := inaccessibility(lake, 0, 64)
+	require.True(t, n <= 64, "probes = %d", n)
+	require.True(t, distance(c[0], c[1], lake) > 450, "%v", c)
+
+	// a long thin polygon is covered by a few cells
+	strip := polygon([]geom.Coordinate{{0, 0}, {100000, 0}, {100000, 1}, {0, 1}, {0, 0}})
+	c, n = inaccessibility(strip, 0, 64)
+	require.True(t, n <= 64, "probes = %d", n)
+	require.InDelta(t, 0.5, c[1], 0.01)
+}
+
+func TestLabelSurface(t *testing.T) {
+	lb := &labeller{method: LabelSurface}
+
+	u := polygon([]geom.Coordinate{{0, 0}, {30, 0}, {30, 30}, {20, 30}, {20, 10}, {10, 10}, {10, 30}, {0, 30}, {0, 0}})
+	p := lb.label(u, 0)
+	require.Equal(t, geom.Coordinate{5, 15}, p.Coordinate)
+	require.True(t, distance(p.Coordinate[0], p.Coordinate[1], u) > 0)
+
+	flat := polygon([]geom.Coordinate{{0, 0}, {10, 0}, {0, 0}})
+	require.Equal(t, geom.Coordinate{0, 0}, lb.label(flat, 0).Coordinate)
+}
+
+func TestDistance(t *testing.T) {
+	square := polygon([]geom.Coordinate{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}})
+
+	require.Equal(t, 2.0, distance(2, 5, square))
+	require.Equal(t, -3.0, distance(-3, 5, square))
+	require.Equal(t, math.Sqrt(2), -distance(11, 11, square))
+}
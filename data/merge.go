@@ -0,0 +1,490 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/devork/geom"
+	"github.com/devork/grava/config"
+)
+
+// merger combines the lines and polygons of a layer which share the same key attributes. It runs on the tile space
+// geometries clipped to the read margin, before they are generalized (see Layer.rework): coordinates have been snapped
+// to tile pixels, so touching features meet exactly, and boundaries shared by neighbouring polygons haven't yet been
+// simplified apart.
+type merger struct {
+	maxZoom *int
+	keys    []string
+}
+
+// newMerger validates the merge configuration of a layer, returning nil if the layer isn't merged
+func newMerger(layer string, cfg *config.Merge) (*merger, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	if len(cfg.Keys) == 0 {
+		return nil, fmt.Errorf("merge needs at least one key attribute: layer = %s", layer)
+	}
+
+	return &merger{maxZoom: cfg.MaxZoom, keys: cfg.Keys}, nil
+}
+
+// applies checks if features are merged at the given zoom
+func (m *merger) applies(zoom int) bool {
+	return m != nil && (m.maxZoom == nil || zoom <= *m.maxZoom)
+}
+
+// attributes limits the attributes which aren't keys to the zooms above the merge, merged features only having their
+// keys. It is an error for a key not to be an attribute of the layer.
+func (m *merger) attributes(layer string, attrs []Attribute) ([]Attribute, error) {
+	keys := set(m.keys)
+	names := map[string]bool{}
+
+	out := make([]Attribute, 0, len(attrs))
+	for _, attr := range attrs {
+		names[attr.Name] = true
+
+		if keys[attr.Name] {
+			out = append(out, attr)
+			continue
+		}
+
+		if m.maxZoom == nil {
+			continue
+		}
+
+		if attr.MinZoom == nil || *attr.MinZoom <= *m.maxZoom {
+			min := *m.maxZoom + 1
+			attr.MinZoom = &min
+		}
+
+		if attr.MaxZoom == nil || *attr.MaxZoom >= *attr.MinZoom {
+			out = append(out, attr)
+		}
+	}
+
+	for _, key := range m.keys {
+		if !names[key] {
+			return nil, fmt.Errorf("merge key is not an attribute: layer = %s, key = %s", layer, key)
+		}
+	}
+
+	return out, nil
+}
+
+// merge combines the lines and the polygons with the same keys into a single feature each, having just the key
+// attributes. Touching lines are joined end to end and polygons sharing edges are unioned. Points are left as they
+// are.
+func (m *merger) merge(features []*feature) []*feature {
+	type group struct {
+		props    []property
+		lines    [][]geom.Coordinate
+		polygons []geom.Polygon
+	}
+
+	var order []string
+	groups := map[string]*group{}
+	var out []*feature
+
+	for _, f := range features {
+		var lines [][]geom.Coordinate
+		var polygons []geom.Polygon
+
+		switch g := f.geom.(type) {
+		case *geom.LineString:
+			lines = append(lines, g.Coordinates)
+		case *geom.MultiLineString:
+			for _, ls := range g.LineStrings {
+				lines = append(lines, ls.Coordinates)
+			}
+		case *geom.Polygon:
+			polygons = append(polygons, *g)
+		case *geom.MultiPolygon:
+			polygons = append(polygons, g.Polygons...)
+		default:
+			out = append(out, f)
+			continue
+		}
+
+		// lines and polygons are kept apart
+		kind := "line"
+		if polygons != nil {
+			kind = "polygon"
+		}
+
+		var props []property
+		parts := []string{kind}
+		for _, key := range m.keys {
+			v := f.prop(key)
+			parts = append(parts, fmt.Sprintf("%T:%v", v, v))
+
+			if v != nil {
+				props = append(props, property{key, v})
+			}
+		}
+
+		k := strings.Join(parts, "\x00")
+		grp, ok := groups[k]
+
+		if !ok {
+			grp = &group{props: props}
+			groups[k] = grp
+			order = append(order, k)
+		}
+
+		grp.lines = append(grp.lines, lines...)
+		grp.polygons = append(grp.polygons, polygons...)
+	}
+
+	for _, k := range order {
+		grp := groups[k]
+
+		var g geom.Geometry
+		if grp.polygons != nil {
+			g = multiPolygon(union(grp.polygons))
+		} else {
+			g = multiLine(joinLines(grp.lines))
+		}
+
+		if g != nil {
+			out = append(out, &feature{geom: g, props: grp.props})
+		}
+	}
+
+	return out
+}
+
+// vertex is a coordinate usable as a map key
+type vertex [2]float64
+
+func vertexOf(c geom.Coordinate) vertex {
+	return vertex{c[0], c[1]}
+}
+
+// joinLines joins lines end to end where exactly two line ends meet (lines are reversed as needed). Lines are not
+// joined through junctions of three or more lines.
+func joinLines(lines [][]geom.Coordinate) [][]geom.Coordinate {
+	ends := map[vertex][]int{}
+	for idx, cs := range lines {
+		if len(cs) < 2 {
+			continue
+		}
+		ends[vertexOf(cs[0])] = append(ends[vertexOf(cs[0])], idx)
+		ends[vertexOf(cs[len(cs)-1])] = append(ends[vertexOf(cs[len(cs)-1])], idx)
+	}
+
+	used := make([]bool, len(lines))
+	var out [][]geom.Coordinate
+
+	// follows the line from its last point through the nodes where only two lines meet
+	extend := func(chain []geom.Coordinate) []geom.Coordinate {
+		for {
+			end := vertexOf(chain[len(chain)-1])
+			if len(ends[end]) != 2 {
+				return chain
+			}
+
+			next := -1
+			for _, idx := range ends[end] {
+				if !used[idx] {
+					next = idx
+				}
+			}
+
+			if next < 0 {
+				return chain
+			}
+
+			used[next] = true
+			cs := lines[next]
+			if vertexOf(cs[0]) != end {
+				cs = reversed(cs)
+			}
+
+			chain = append(chain, cs[1:]...)
+		}
+	}
+
+	// chains start at line ends which aren't joined, then any loops are left
+	for _, loops := range []bool{false, true} {
+		for idx, cs := range lines {
+			if used[idx] || len(cs) < 2 {
+				continue
+			}
+
+			start, end := len(ends[vertexOf(cs[0])]) != 2, len(ends[vertexOf(cs[len(cs)-1])]) != 2
+			if !loops && !start && !end {
+				continue
+			}
+
+			chain := append([]geom.Coordinate{}, cs...)
+			if !start && end {
+				chain = reversed(chain)
+			}
+
+			used[idx] = true
+			out = append(out, extend(chain))
+		}
+	}
+
+	return out
+}
+
+func reversed(cs []geom.Coordinate) []geom.Coordinate {
+	out := make([]geom.Coordinate, len(cs))
+	for idx, c := range cs {
+		out[len(cs)-1-idx] = c
+	}
+	return out
+}
+
+// edge is a directed edge of a ring
+type edge struct {
+	a, b vertex
+}
+
+// union merges polygons sharing edges: with the exterior rings all wound one way and holes the other, an edge shared by
+// two polygons is walked in opposite directions, so the shared edges are removed and the remaining edges are linked
+// back up into rings. Edges are split where another ring has a vertex on them, so a boundary with an extra vertex on
+// one side still cancels out. Polygons which overlap rather than share edges are not merged.
+func union(polygons []geom.Polygon) []geom.Polygon {
+	if len(polygons) == 1 {
+		return polygons
+	}
+
+	var edges []edge
+
+	for _, p := range polygons {
+		for idx, ring := range p.Rings {
+			cs := dedupe(ring.Coordinates)
+
+			// exteriors positive, holes negative
+			if (area(cs) > 0) != (idx == 0) {
+				cs = reversed(cs)
+			}
+
+			for i := 1; i < len(cs); i++ {
+				edges = append(edges, edge{vertexOf(cs[i-1]), vertexOf(cs[i])})
+			}
+		}
+	}
+
+	edges = split(edges)
+
+	count := map[edge]int{}
+	for _, e := range edges {
+		count[e]++
+	}
+
+	// edges shared with another polygon cancel out
+	from := map[vertex][]int{}
+	var kept []edge
+	for _, e := range edges {
+		reverse := edge{e.b, e.a}
+		if count[reverse] > 0 {
+			continue
+		}
+
+		from[e.a] = append(from[e.a], len(kept))
+		kept = append(kept, e)
+	}
+
+	used := make([]bool, len(kept))
+	var exteriors, holes [][]geom.Coordinate
+
+	for idx, e := range kept {
+		if used[idx] {
+			continue
+		}
+
+		used[idx] = true
+		ring := []geom.Coordinate{{e.a[0], e.a[1]}, {e.b[0], e.b[1]}}
+		cur := e
+
+		for cur.b != e.a {
+			next := -1
+			for _, n := range from[cur.b] {
+				if !used[n] {
+					next = n
+					break
+				}
+			}
+
+			if next < 0 {
+				break
+			}
+
+			used[next] = true
+			cur = kept[next]
+			ring = append(ring, geom.Coordinate{cur.b[0], cur.b[1]})
+		}
+
+		if cur.b != e.a || len(ring) < 4 {
+			// not closed, or no area
+			continue
+		}
+
+		switch a := area(ring); {
+		case a > 0:
+			exteriors = append(exteriors, ring)
+		case a < 0:
+			holes = append(holes, ring)
+		}
+	}
+
+	out := make([]geom.Polygon, len(exteriors))
+	for idx, ring := range exteriors {
+		out[idx].Rings = []geom.LinearRing{{Coordinates: ring}}
+	}
+
+	// holes go in the smallest exterior containing them
+	for _, hole := range holes {
+		best, smallest := -1, 0.0
+		for idx, ring := range exteriors {
+			if a := area(ring); encloses(ring, hole) && (best < 0 || a < smallest) {
+				best, smallest = idx, a
+			}
+		}
+
+		if best >= 0 {
+			out[best].Rings = append(out[best].Rings, geom.LinearRing{Coordinates: hole})
+		}
+	}
+
+	return out
+}
+
+// splitCell is the size (in tile pixels) of the grid indexing the vertices edges may be split at
+const splitCell = 64.0
+
+// split splits the edges which aren't matched by a reverse edge at the vertices of other unmatched edges lying on them
+// (T-junctions), so boundaries shared by two polygons but noded differently on each side match up
+func split(edges []edge) []edge {
+	present := map[edge]bool{}
+	for _, e := range edges {
+		present[e] = true
+	}
+
+	cellOf := func(x, y float64) cell {
+		return cell{int(math.Floor(x / splitCell)), int(math.Floor(y / splitCell))}
+	}
+
+	// the vertices of the unmatched edges, by grid cell
+	grid := map[cell][]vertex{}
+	seen := map[vertex]bool{}
+	for _, e := range edges {
+		if present[edge{e.b, e.a}] {
+			continue
+		}
+
+		for _, v := range []vertex{e.a, e.b} {
+			if !seen[v] {
+				seen[v] = true
+				k := cellOf(v[0], v[1])
+				grid[k] = append(grid[k], v)
+			}
+		}
+	}
+
+	out := make([]edge, 0, len(edges))
+	for _, e := range edges {
+		if present[edge{e.b, e.a}] {
+			out = append(out, e)
+			continue
+		}
+
+		dx, dy := e.b[0]-e.a[0], e.b[1]-e.a[1]
+		l2 := dx*dx + dy*dy
+
+		// the cells along the edge (and their neighbours, as samples are half a cell apart)
+		type cut struct {
+			t float64
+			v vertex
+		}
+		var cuts []cut
+		visited := map[cell]bool{}
+
+		steps := math.Max(1, math.Ceil(math.Sqrt(l2)/(splitCell/2)))
+		for i := 0.0; i <= steps; i++ {
+			c := cellOf(e.a[0]+dx*i/steps, e.a[1]+dy*i/steps)
+
+			for x := c.x - 1; x <= c.x+1; x++ {
+				for y := c.y - 1; y <= c.y+1; y++ {
+					k := cell{x, y}
+					if visited[k] {
+						continue
+					}
+					visited[k] = true
+
+					for _, v := range grid[k] {
+						// on the line (coordinates are whole pixels, so this is exact) and strictly between the ends
+						vx, vy := v[0]-e.a[0], v[1]-e.a[1]
+						if dx*vy-dy*vx != 0 {
+							continue
+						}
+
+						if t := dx*vx + dy*vy; t > 0 && t < l2 {
+							cuts = append(cuts, cut{t, v})
+						}
+					}
+				}
+			}
+		}
+
+		sort.Slice(cuts, func(i, j int) bool { return cuts[i].t < cuts[j].t })
+
+		a := e.a
+		for _, c := range cuts {
+			out = append(out, edge{a, c.v})
+			a = c.v
+		}
+		out = append(out, edge{a, e.b})
+	}
+
+	return out
+}
+
+// encloses checks if the hole is inside the ring, testing the middle of the hole's first edge (the hole's points can
+// lie on the ring)
+func encloses(ring, hole []geom.Coordinate) bool {
+	x, y := (hole[0][0]+hole[1][0])/2, (hole[0][1]+hole[1][1])/2
+
+	in := false
+	for idx := 1; idx < len(ring); idx++ {
+		a, b := ring[idx-1], ring[idx]
+		if (a[1] > y) != (b[1] > y) && x < (b[0]-a[0])*(y-a[1])/(b[1]-a[1])+a[0] {
+			in = !in
+		}
+	}
+
+	return in
+}
+
+func multiLine(lines [][]geom.Coordinate) geom.Geometry {
+	switch len(lines) {
+	case 0:
+		return nil
+	case 1:
+		return &geom.LineString{Coordinates: lines[0]}
+	}
+
+	out := &geom.MultiLineString{LineStrings: make([]geom.LineString, len(lines))}
+	for idx, cs := range lines {
+		out.LineStrings[idx] = geom.LineString{Coordinates: cs}
+	}
+	return out
+}
+
+func multiPolygon(polygons []geom.Polygon) geom.Geometry {
+	switch len(polygons) {
+	case 0:
+		return nil
+	case 1:
+		return &polygons[0]
+	}
+
+	return &geom.MultiPolygon{Polygons: polygons}
+}
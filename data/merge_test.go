@@ -0,0 +1,150 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/devork/geom"
+	"github.com/devork/grava/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMerger(t *testing.T) {
+	m, err := newMerger("road", nil)
+	require.NoError(t, err)
+	require.Nil(t, m)
+	require.False(t, m.applies(0))
+
+	_, err = newMerger("road", &config.Merge{})
+	require.Error(t, err)
+
+	z, five := 11, 5
+	m, err = newMerger("road", &config.Merge{MaxZoom: &z, Keys: []string{"class"}})
+	require.NoError(t, err)
+	require.True(t, m.applies(11))
+	require.False(t, m.applies(12))
+
+	attrs, err := m.attributes("road", []Attribute{
+		{Name: "class", Type: TypeString},
+		{Name: "name", Type: TypeString},
+		{Name: "ref", Type: TypeString, MaxZoom: &five},
+	})
+	require.NoError(t, err)
+
+	min := 12
+	require.Equal(t, []Attribute{
+		{Name: "class", Type: TypeString},
+		{Name: "name", Type: TypeString, MinZoom: &min},
+	}, attrs)
+
+	_, err = m.attributes("road", []Attribute{{Name: "name", Type: TypeString}})
+	require.Error(t, err)
+}
+
+func TestMergeLines(t *testing.T) {
+	m := &merger{keys: []string{"class"}}
+
+	a := property{"class", "A Road"}
+	b := property{"class", "B Road"}
+
+	features := m.merge([]*feature{
+		line([]geom.Coordinate{{0, 0}, {10, 0}}, a, property{"name", "x"}),
+		line([]geom.Coordinate{{20, 0}, {10, 0}}, a, property{"name", "y"}),
+		line([]geom.Coordinate{{0, 5}, {10, 5}}, b),
+		line([]geom.Coordinate{{20, 0}, {30, 0}}, a),
+		point(1, 1, a),
+	})
+
+	require.Len(t, features, 3)
+	require.IsType(t, &geom.Point{}, features[0].geom)
+
+	require.Equal(t, []property{a}, features[1].props)
+	require.Equal(t, &geom.LineString{Coordinates: []geom.Coordinate{{0, 0}, {10, 0}, {20, 0}, {30, 0}}}, features[1].geom)
+
+	require.Equal(t, []property{b}, features[2].props)
+	require.Equal(t, &geom.LineString{Coordinates: []geom.Coordinate{{0, 5}, {10, 5}}}, features[2].geom)
+}
+
+func TestJoinLines(t *testing.T) {
+	// three lines meeting at (10, 0) aren't joined
+	lines := joinLines([][]geom.Coordinate{
+		{{0, 0}, {10, 0}},
+		{{10, 0}, {20, 0}},
+		{{10, 0}, {10, 10}},
+	})
+	require.Len(t, lines, 3)
+
+	// loops are joined into a closed line
+	lines = joinLines([][]geom.Coordinate{
+		{{0, 0}, {10, 0}, {10, 10}},
+		{{0, 0}, {0, 10}, {10, 10}},
+	})
+	require.Equal(t, [][]geom.Coordinate{{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}}, lines)
+}
+
+func TestUnion(t *testing.T) {
+	// two squares side by side
+	polygons := union([]geom.Polygon{square(0, 0, 10), square(10, 0, 10)})
+	require.Len(t, polygons, 1)
+	require.Len(t, polygons[0].Rings, 1)
+	require.Equal(t, 2*200.0, area(polygons[0].Rings[0].Coordinates))
+
+	// squares apart are left apart
+	polygons = union([]geom.Polygon{square(0, 0, 10), square(20, 0, 10)})
+	require.Len(t, polygons, 2)
+
+	// a ring of squares around a hole
+	var ring []geom.Polygon
+	for x := 0.0; x < 30; x += 10 {
+		for y := 0.0; y < 30; y += 10 {
+			if x != 10 || y != 10 {
+				ring = append(ring, square(x, y, 10))
+			}
+		}
+	}
+
+	polygons = union(ring)
+	require.Len(t, polygons, 1)
+	require.Len(t, polygons[0].Rings, 2)
+	require.Equal(t, 2*900.0, area(polygons[0].Rings[0].Coordinates))
+	require.Equal(t, -2*100.0, area(polygons[0].Rings[1].Coordinates))
+
+	// filling the hole removes it
+	polygons = union(append(ring, square(10, 10, 10)))
+	require.Len(t, polygons, 1)
+	require.Len(t, polygons[0].Rings, 1)
+
+	// a T-junction: two squares against the side of a larger one, which has no vertex where they meet
+	polygons = union([]geom.Polygon{square(0, 0, 20), square(20, 0, 10), square(20, 10, 10)})
+	require.Len(t, polygons, 1)
+	require.Len(t, polygons[0].Rings, 1)
+	require.Equal(t, 2*600.0, area(polygons[0].Rings[0].Coordinates))
+}
+
+func TestMergeGeneralized(t *testing.T) {
+	lyr := &Layer{extent: 100, buffer: 10, merge: &merger{keys: []string{"class"}}}
+	gen := &generalizer{tolerance: 3}
+	outer := newClipper(100, 20)
+	clip := newClipper(100, 10)
+
+	// two fields either side of a wiggly hedge, which generalizing each field on its own would straighten differently.
+	// The fields reach past the area read for the tile.
+	var hedge []geom.Coordinate
+	for y := -50.0; y <= 150; y += 5 {
+		hedge = append(hedge, geom.Coordinate{50 + float64(int(y/5+10)*4%7), y})
+	}
+
+	left := append([]geom.Coordinate{{-50, 150}, {-50, -50}}, hedge...)
+	right := append(append([]geom.Coordinate{{150, -50}, {150, 150}}, reversed(hedge)...), geom.Coordinate{150, -50})
+
+	// as read for the tile: clipped to the read margin, but not generalized
+	fields := []*feature{
+		{geom: outer.clip(polygon(append(left, left[0]))), props: []property{{"class", "field"}}},
+		{geom: outer.clip(polygon(right)), props: []property{{"class", "field"}}},
+	}
+
+	features := lyr.rework(fields, 0, geom.Coordinate{0, 0}, gen, outer, clip)
+	require.Len(t, features, 1)
+	require.IsType(t, &geom.Polygon{}, features[0].geom)
+	require.Len(t, features[0].geom.(*geom.Polygon).Rings, 1)
+	require.Equal(t, 2*120*120.0, area(features[0].geom.(*geom.Polygon).Rings[0].Coordinates))
+}
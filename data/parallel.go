@@ -0,0 +1,61 @@
+package data
+
+import (
+	"context"
+	"sync"
+)
+
+// parallel calls fn for each index in [0, n), running at most limit calls at a time. The first error cancels the
+// context passed to the calls still running (and stops any more being started) and is returned once all running
+// calls have finished. Callers keep results in order by storing them against the index.
+func parallel(ctx context.Context, n, limit int, fn func(ctx context.Context, idx int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if limit <= 0 {
+		limit = 1
+	}
+
+	sem := make(chan struct{}, limit)
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var first error
+
+loop:
+	for idx := 0; idx < n; idx++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break loop
+		}
+
+		// select picks at random when both are ready, so work could still be started after cancellation
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := fn(ctx, idx); err != nil {
+				once.Do(func() {
+					first = err
+					cancel()
+				})
+			}
+		}(idx)
+	}
+
+	wg.Wait()
+
+	if first != nil {
+		return first
+	}
+
+	// the parent context may have been cancelled before all calls were started
+	return ctx.Err()
+}
@@ -0,0 +1,87 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParallelLimit(t *testing.T) {
+	var running, max int32
+	results := make([]int, 20)
+
+	err := parallel(context.Background(), len(results), 3, func(ctx context.Context, idx int) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+
+		results[idx] = idx * 2
+		return nil
+	})
+
+	require.NoError(t, err)
+	require.True(t, max <= 3, "expected at most 3 concurrent calls: max = %d", max)
+
+	for idx, v := range results {
+		require.Equal(t, idx*2, v)
+	}
+}
+
+func TestParallelCancel(t *testing.T) {
+	failure := errors.New("failed")
+	var cancelled int32
+
+	err := parallel(context.Background(), 10, 2, func(ctx context.Context, idx int) error {
+		if idx == 0 {
+			return failure
+		}
+
+		<-ctx.Done()
+		atomic.AddInt32(&cancelled, 1)
+		return ctx.Err()
+	})
+
+	require.Equal(t, failure, err)
+	require.True(t, cancelled < 9, "expected remaining calls not to be started")
+}
+
+func TestParallelCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the semaphore is always ready, so only the context check stops the calls
+	for i := 0; i < 100; i++ {
+		var started int32
+		err := parallel(ctx, 10, 10, func(ctx context.Context, idx int) error {
+			atomic.AddInt32(&started, 1)
+			return nil
+		})
+
+		require.Equal(t, context.Canceled, err)
+		require.Zero(t, started)
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	failure := errors.New("failed")
+
+	require.Equal(t, failure, outcome(context.Background(), failure))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Equal(t, ErrCancelled, outcome(ctx, failure))
+
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+	require.Equal(t, ErrTimeout, outcome(ctx, failure))
+}
@@ -0,0 +1,176 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/devork/grava/config"
+	"github.com/jackc/pgx"
+)
+
+// Pool defaults, used when the database configuration leaves them out
+var (
+	defaultMaxConnections = 10
+	defaultLogLevel       = pgx.LogLevelError
+)
+
+// PoolStats is a snapshot of the connection pool usage
+type PoolStats struct {
+	MaxConnections int     `json:"maxConnections"`
+	Acquired       int     `json:"acquired"`
+	Idle           int     `json:"idle"`
+	Waits          uint64  `json:"waits"`
+	WaitSeconds    float64 `json:"waitSeconds"`
+}
+
+// minWait is the time past which acquiring a connection counts as a wait. Taking an idle connection from the pool
+// takes microseconds, an acquire that takes longer has queued for a connection or opened a new one.
+const minWait = time.Millisecond
+
+// pool wraps the connection pool to time how long tile queries wait to acquire a connection, as pgx itself only reports
+// the current state of the pool. The queries reading layer metadata at startup aren't timed.
+type pool struct {
+	*pgx.ConnPool
+	waits    uint64
+	waitTime int64
+}
+
+// rows releases the connection of the query back to the pool once closed
+type rows struct {
+	*pgx.Rows
+	p    *pool
+	conn *pgx.Conn
+}
+
+// Close closes the rows and releases the connection, it is safe to call more than once
+func (r *rows) Close() {
+	r.Rows.Close()
+
+	if r.conn != nil {
+		r.p.Release(r.conn)
+		r.conn = nil
+	}
+}
+
+// row is the result of a single row query, see pgx.Row
+type row struct {
+	rows *rows
+	err  error
+}
+
+// Scan reads the first row into dest and releases the connection, see pgx.Row.Scan
+func (r *row) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	defer r.rows.Close()
+	return (*pgx.Row)(r.rows.Rows).Scan(dest...)
+}
+
+// QueryEx runs the query on a pooled connection, which is released when the rows are closed, see pgx.ConnPool.QueryEx
+func (p *pool) QueryEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) (*rows, error) {
+	conn, err := p.acquire(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := conn.QueryEx(ctx, sql, options, args...)
+
+	if err != nil {
+		p.Release(conn)
+		return nil, err
+	}
+
+	return &rows{Rows: rs, p: p, conn: conn}, nil
+}
+
+// QueryRowEx runs the single row query on a pooled connection, which is released once the row is scanned, see
+// pgx.ConnPool.QueryRowEx
+func (p *pool) QueryRowEx(ctx context.Context, sql string, options *pgx.QueryExOptions, args ...interface{}) *row {
+	rs, err := p.QueryEx(ctx, sql, options, args...)
+	return &row{rows: rs, err: err}
+}
+
+// acquire takes a connection from the pool, counting it as a wait if it took longer than minWait
+func (p *pool) acquire(ctx context.Context) (*pgx.Conn, error) {
+	start := time.Now()
+	conn, err := p.AcquireEx(ctx)
+	p.wait(time.Since(start))
+
+	return conn, err
+}
+
+// wait records the time taken to acquire a connection
+func (p *pool) wait(d time.Duration) {
+	if d < minWait {
+		return
+	}
+
+	atomic.AddUint64(&p.waits, 1)
+	atomic.AddInt64(&p.waitTime, int64(d))
+}
+
+// stats returns the current pool usage
+func (p *pool) stats() PoolStats {
+	s := p.Stat()
+
+	return PoolStats{
+		MaxConnections: s.MaxConnections,
+		Acquired:       s.CurrentConnections - s.AvailableConnections,
+		Idle:           s.AvailableConnections,
+		Waits:          atomic.LoadUint64(&p.waits),
+		WaitSeconds:    time.Duration(atomic.LoadInt64(&p.waitTime)).Seconds(),
+	}
+}
+
+// poolConfig creates the pool configuration from the connection settings and the database section of the config.
+// Session settings are added to the run-time parameters, overriding any given in the connection string.
+func poolConfig(pcon pgx.ConnConfig, cfg config.Database) (pgx.ConnPoolConfig, error) {
+	pcon.Logger = &logger{}
+	pcon.LogLevel = defaultLogLevel
+
+	if cfg.LogLevel != "" {
+		lvl, err := pgx.LogLevelFromString(cfg.LogLevel)
+
+		if err != nil {
+			return pgx.ConnPoolConfig{}, fmt.Errorf("invalid database log level: level = %s", cfg.LogLevel)
+		}
+
+		pcon.LogLevel = int(lvl)
+	}
+
+	params := map[string]string{}
+	for k, v := range pcon.RuntimeParams {
+		params[k] = v
+	}
+
+	if cfg.StatementTimeout > 0 {
+		params["statement_timeout"] = strconv.FormatInt(int64(time.Duration(cfg.StatementTimeout)/time.Millisecond), 10)
+	}
+
+	if cfg.ApplicationName != "" {
+		params["application_name"] = cfg.ApplicationName
+	}
+
+	if cfg.SearchPath != "" {
+		params["search_path"] = cfg.SearchPath
+	}
+
+	pcon.RuntimeParams = params
+
+	max := defaultMaxConnections
+	if cfg.MaxConnections > 0 {
+		max = cfg.MaxConnections
+	}
+
+	return pgx.ConnPoolConfig{
+		ConnConfig:     pcon,
+		MaxConnections: max,
+		AcquireTimeout: time.Duration(cfg.AcquireTimeout),
+	}, nil
+}
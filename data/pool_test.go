@@ -0,0 +1,63 @@
+package data
+
+import (
+	"testing"
+	"time"
+
+	"github.com/devork/grava/config"
+	"github.com/jackc/pgx"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoolConfigDefaults(t *testing.T) {
+	pcon := pgx.ConnConfig{RuntimeParams: map[string]string{"application_name": "psql"}}
+
+	pcfg, err := poolConfig(pcon, config.Database{})
+
+	require.NoError(t, err)
+	require.Equal(t, 10, pcfg.MaxConnections)
+	require.Equal(t, time.Duration(0), pcfg.AcquireTimeout)
+	require.Equal(t, pgx.LogLevelError, pcfg.LogLevel)
+	require.Equal(t, map[string]string{"application_name": "psql"}, pcfg.RuntimeParams)
+}
+
+func TestPoolConfig(t *testing.T) {
+	pcon := pgx.ConnConfig{RuntimeParams: map[string]string{"application_name": "psql"}}
+
+	pcfg, err := poolConfig(pcon, config.Database{
+		MaxConnections:   20,
+		AcquireTimeout:   config.Duration(2 * time.Second),
+		StatementTimeout: config.Duration(1500 * time.Millisecond),
+		ApplicationName:  "gravad",
+		SearchPath:       "grava,public",
+		LogLevel:         "warn",
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, 20, pcfg.MaxConnections)
+	require.Equal(t, 2*time.Second, pcfg.AcquireTimeout)
+	require.Equal(t, pgx.LogLevelWarn, pcfg.LogLevel)
+	require.Equal(t, map[string]string{
+		"application_name":  "gravad",
+		"search_path":       "grava,public",
+		"statement_timeout": "1500",
+	}, pcfg.RuntimeParams)
+
+	// the connection config is left untouched
+	require.Equal(t, "psql", pcon.RuntimeParams["application_name"])
+
+	_, err = poolConfig(pcon, config.Database{LogLevel: "loud"})
+	require.Error(t, err)
+}
+
+func TestPoolWait(t *testing.T) {
+	p := &pool{}
+
+	// an idle connection taken straight from the pool isn't a wait
+	p.wait(50 * time.Microsecond)
+	p.wait(20 * time.Millisecond)
+	p.wait(30 * time.Millisecond)
+
+	require.Equal(t, uint64(2), p.waits)
+	require.Equal(t, int64(50*time.Millisecond), p.waitTime)
+}
@@ -0,0 +1,144 @@
+package data
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/devork/grava/geo"
+)
+
+// Tokens which can be used in layer SQL. These are replaced with bind parameters when the layer is read, the values
+// being supplied for each tile request.
+const (
+	// TokenBBox is the tile envelope (grown by the layer buffer) in the SRID of the tile
+	TokenBBox = "!BBOX!"
+
+	// TokenTileBBox is the exact tile envelope in the SRID of the tile
+	TokenTileBBox = "!TILE_BBOX!"
+
+	// TokenZoom is the zoom level of the tile
+	TokenZoom = "!ZOOM!"
+
+	// TokenPixelWidth is the width of a single tile pixel (one unit of the tile extent) in ground units
+	TokenPixelWidth = "!PIXEL_WIDTH!"
+
+	// TokenScaleDenominator is the OGC scale denominator of the tile (based on 256 pixel tiles and 0.28mm pixels)
+	TokenScaleDenominator = "!SCALE_DENOMINATOR!"
+)
+
+// param identifies the value bound to a query parameter
+type param int
+
+const (
+	paramMinx param = iota
+	paramMiny
+	paramMaxx
+	paramMaxy
+	paramSrid
+	paramTileMinx
+	paramTileMiny
+	paramTileMaxx
+	paramTileMaxy
+	paramZoom
+	paramPixelWidth
+	paramScaleDenominator
+)
+
+// bind replaces the tokens in sql with numbered bind parameters, returning the parameters in order. Repeated tokens
+// share the same parameters.
+func bind(sql string) (string, []param) {
+	params := []param{}
+	index := map[param]int{}
+
+	placeholder := func(p param, cast string) string {
+		idx, ok := index[p]
+
+		if !ok {
+			params = append(params, p)
+			idx = len(params)
+			index[p] = idx
+		}
+
+		return fmt.Sprintf("$%d::%s", idx, cast)
+	}
+
+	for _, token := range []string{TokenBBox, TokenTileBBox, TokenZoom, TokenPixelWidth, TokenScaleDenominator} {
+		if !strings.Contains(sql, token) {
+			continue
+		}
+
+		var value string
+
+		switch token {
+		case TokenBBox:
+			value = fmt.Sprintf(
+				"st_makeenvelope(%s, %s, %s, %s, %s)",
+				placeholder(paramMinx, "float8"),
+				placeholder(paramMiny, "float8"),
+				placeholder(paramMaxx, "float8"),
+				placeholder(paramMaxy, "float8"),
+				placeholder(paramSrid, "int4"),
+			)
+		case TokenTileBBox:
+			value = fmt.Sprintf(
+				"st_makeenvelope(%s, %s, %s, %s, %s)",
+				placeholder(paramTileMinx, "float8"),
+				placeholder(paramTileMiny, "float8"),
+				placeholder(paramTileMaxx, "float8"),
+				placeholder(paramTileMaxy, "float8"),
+				placeholder(paramSrid, "int4"),
+			)
+		case TokenZoom:
+			value = placeholder(paramZoom, "int4")
+		case TokenPixelWidth:
+			value = placeholder(paramPixelWidth, "float8")
+		case TokenScaleDenominator:
+			value = placeholder(paramScaleDenominator, "float8")
+		}
+
+		sql = strings.Replace(sql, token, value, -1)
+	}
+
+	return sql, params
+}
+
+// args creates the bind parameter values for a tile. The box is the tile itself, query is the tile grown by the
+// layer buffer and extent the tile extent of the layer.
+func args(params []param, box, query *geo.BBox, zoom int, extent uint32) []interface{} {
+	values := make([]interface{}, len(params))
+
+	// size of the tile in ground units
+	size := math.Abs(box.Maxx - box.Minx)
+
+	for idx, p := range params {
+		switch p {
+		case paramMinx:
+			values[idx] = query.Minx
+		case paramMiny:
+			values[idx] = query.Miny
+		case paramMaxx:
+			values[idx] = query.Maxx
+		case paramMaxy:
+			values[idx] = query.Maxy
+		case paramSrid:
+			values[idx] = int32(box.Srid)
+		case paramTileMinx:
+			values[idx] = box.Minx
+		case paramTileMiny:
+			values[idx] = box.Miny
+		case paramTileMaxx:
+			values[idx] = box.Maxx
+		case paramTileMaxy:
+			values[idx] = box.Maxy
+		case paramZoom:
+			values[idx] = int32(zoom)
+		case paramPixelWidth:
+			values[idx] = size / float64(extent)
+		case paramScaleDenominator:
+			values[idx] = size / 256 / 0.00028
+		}
+	}
+
+	return values
+}
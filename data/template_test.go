@@ -0,0 +1,34 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/devork/grava/geo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBind(t *testing.T) {
+	sql, params := bind("select geometry from road where geometry && !BBOX! and !ZOOM! > 10 and st_area(geometry) > !PIXEL_WIDTH! and geometry && !BBOX!")
+
+	require.Equal(t,
+		"select geometry from road where geometry && st_makeenvelope($1::float8, $2::float8, $3::float8, $4::float8, $5::int4) "+
+			"and $6::int4 > 10 and st_area(geometry) > $7::float8 "+
+			"and geometry && st_makeenvelope($1::float8, $2::float8, $3::float8, $4::float8, $5::int4)",
+		sql,
+	)
+	require.Equal(t, []param{paramMinx, paramMiny, paramMaxx, paramMaxy, paramSrid, paramZoom, paramPixelWidth}, params)
+
+	box := &geo.BBox{Minx: 0, Miny: 0, Maxx: 4096, Maxy: 4096, Srid: 3857}
+	query := &geo.BBox{Minx: -1, Miny: -2, Maxx: 4097, Maxy: 4098, Srid: 3857}
+
+	values := args(params, box, query, 12, 4096)
+	require.Equal(t, []interface{}{-1.0, -2.0, 4097.0, 4098.0, int32(3857), int32(12), 1.0}, values)
+
+	// pixel width follows the layer extent
+	values = args(params, box, query, 12, 512)
+	require.Equal(t, 8.0, values[6])
+
+	sql, params = bind("select !TILE_BBOX!")
+	require.Equal(t, "select st_makeenvelope($1::float8, $2::float8, $3::float8, $4::float8, $5::int4)", sql)
+	require.Equal(t, []interface{}{0.0, 0.0, 4096.0, 4096.0, int32(3857)}, args(params, box, query, 12, 4096))
+}
@@ -0,0 +1,126 @@
+package data
+
+import "fmt"
+
+// Attribute types, as listed in the source metadata. Every attribute column is read as one of these.
+const (
+	TypeInteger = "integer"
+	TypeFloat   = "float"
+	TypeBoolean = "boolean"
+	TypeString  = "string"
+)
+
+// mapping describes how a Postgres type is read: the attribute type used by default and the SQL expression (with %[1]s
+// being the column) for each attribute type the column can be read as.
+type mapping struct {
+	def   string
+	reads map[string]string
+}
+
+var (
+	integers = mapping{TypeInteger, map[string]string{
+		TypeInteger: "%[1]s::int8",
+		TypeFloat:   "%[1]s::float8",
+		TypeString:  "%[1]s::text",
+	}}
+
+	floats = mapping{TypeFloat, map[string]string{
+		TypeFloat:   "%[1]s::float8",
+		TypeInteger: "round(%[1]s)::int8",
+		TypeString:  "%[1]s::text",
+	}}
+
+	booleans = mapping{TypeBoolean, map[string]string{
+		TypeBoolean: "%[1]s",
+		TypeString:  "%[1]s::text",
+	}}
+
+	texts = mapping{TypeString, map[string]string{
+		TypeString: "%[1]s::text",
+	}}
+
+	// dates and times are ISO 8601 strings by default, or seconds since the epoch as integers
+	timestamps = mapping{TypeString, map[string]string{
+		TypeString:  "to_json(%[1]s) #>> '{}'",
+		TypeInteger: "extract(epoch from %[1]s)::int8",
+	}}
+
+	intervals = mapping{TypeString, map[string]string{
+		TypeString:  "%[1]s::text",
+		TypeInteger: "extract(epoch from %[1]s)::int8",
+	}}
+
+	arrays = mapping{TypeString, map[string]string{
+		TypeString: "array_to_string(%[1]s, ',')",
+	}}
+)
+
+// mappings holds the supported Postgres types by name
+var mappings = map[string]mapping{
+	"int2":        integers,
+	"int4":        integers,
+	"int8":        integers,
+	"oid":         integers,
+	"float4":      floats,
+	"float8":      floats,
+	"numeric":     floats,
+	"money":       {TypeFloat, map[string]string{TypeFloat: "%[1]s::numeric::float8", TypeString: "%[1]s::text"}},
+	"bool":        booleans,
+	"text":        texts,
+	"varchar":     texts,
+	"bpchar":      texts,
+	"name":        texts,
+	"citext":      texts,
+	"uuid":        texts,
+	"inet":        texts,
+	"cidr":        texts,
+	"macaddr":     texts,
+	"json":        texts,
+	"jsonb":       texts,
+	"hstore":      texts,
+	"xml":         texts,
+	"date":        timestamps,
+	"timestamp":   timestamps,
+	"timestamptz": timestamps,
+	"time":        texts,
+	"timetz":      texts,
+	"interval":    intervals,
+}
+
+// Postgres type categories (pg_type.typcategory) used for types not known by name
+const (
+	categoryArray  = "A"
+	categoryEnum   = "E"
+	categoryString = "S"
+)
+
+// lookup finds the mapping for a column, falling back on the type category for enums, arrays and other string types
+func lookup(c column) (mapping, bool) {
+	if m, ok := mappings[c.udt]; ok {
+		return m, true
+	}
+
+	switch c.category {
+	case categoryEnum, categoryString:
+		return texts, true
+	case categoryArray:
+		return arrays, true
+	}
+
+	return mapping{}, false
+}
+
+// read returns the attribute type and select expression of the column, as overridden by typ if given
+func (m mapping) read(c column, ident, typ string) (string, string, error) {
+	if typ == "" {
+		typ = m.def
+	}
+
+	expr, ok := m.reads[typ]
+
+	if !ok {
+		return "", "", fmt.Errorf("column cannot be read as type: column = %s, udt = %s, type = %s", c.name, c.udt, typ)
+	}
+
+	return typ, fmt.Sprintf(expr, ident), nil
+}
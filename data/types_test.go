@@ -0,0 +1,50 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookup(t *testing.T) {
+	read := func(c column, typ string) (string, string) {
+		m, ok := lookup(c)
+		require.True(t, ok, c.udt)
+
+		typ, expr, err := m.read(c, `"col"`, typ)
+		require.NoError(t, err)
+		return typ, expr
+	}
+
+	typ, expr := read(column{name: "col", udt: "int4"}, "")
+	require.Equal(t, TypeInteger, typ)
+	require.Equal(t, `"col"::int8`, expr)
+
+	typ, expr = read(column{name: "col", udt: "numeric"}, "")
+	require.Equal(t, TypeFloat, typ)
+	require.Equal(t, `"col"::float8`, expr)
+
+	typ, expr = read(column{name: "col", udt: "timestamptz"}, "")
+	require.Equal(t, TypeString, typ)
+	require.Equal(t, `to_json("col") #>> '{}'`, expr)
+
+	typ, expr = read(column{name: "col", udt: "timestamptz"}, TypeInteger)
+	require.Equal(t, TypeInteger, typ)
+	require.Equal(t, `extract(epoch from "col")::int8`, expr)
+
+	// enums and arrays are found by their category
+	typ, expr = read(column{name: "col", udt: "building_type", category: categoryEnum}, "")
+	require.Equal(t, TypeString, typ)
+	require.Equal(t, `"col"::text`, expr)
+
+	typ, expr = read(column{name: "col", udt: "_int4", category: categoryArray}, "")
+	require.Equal(t, TypeString, typ)
+	require.Equal(t, `array_to_string("col", ',')`, expr)
+
+	_, ok := lookup(column{name: "col", udt: "bytea", category: "U"})
+	require.False(t, ok)
+
+	m, _ := lookup(column{name: "col", udt: "uuid"})
+	_, _, err := m.read(column{name: "col", udt: "uuid"}, `"col"`, TypeInteger)
+	require.Error(t, err)
+}
@@ -27,6 +27,15 @@ func NewBBox(x, y, z int) *BBox {
 	return &BBox{Minx: minx, Miny: maxy, Maxx: maxx, Maxy: miny, Srid: 3857}
 }
 
+// LonLat converts a (fractional) tile coordinate at zoom z into longitude/latitude (EPSG:4326). Whole numbers give
+// the north west corner of the tile.
+func LonLat(x, y float64, z int) (long, lat float64) {
+	n := math.Exp2(float64(z))
+	long = x/n*360.0 - 180.0
+	lat = 180.0 / math.Pi * math.Atan(math.Sinh(math.Pi*(1-2*y/n)))
+	return long, lat
+}
+
 func merc(lat, long float64) (x, y float64, err error) {
 	//http://www.maptiler.org/google-maps-coordinates-tile-bounds-projection/
 	if math.Abs(long) > 180 {
@@ -0,0 +1,293 @@
+package vtile
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/devork/geom"
+)
+
+// Decoder errors
+var (
+	ErrInvalidCommand = errors.New("invalid geometry command")
+	ErrInvalidTags    = errors.New("invalid feature tags")
+	ErrInvalidValue   = errors.New("value has no type set")
+)
+
+// Layer is a decoded tile layer
+type Layer struct {
+	Name     string
+	Version  uint32
+	Extent   uint32
+	Features []*Feature
+}
+
+// Feature is a decoded tile feature. The geometry is in tile coordinates (see ToLonLat), properties have their
+// keys and values resolved from the layer dictionaries.
+type Feature struct {
+	ID         *uint64
+	Type       Tile_GeomType
+	Geometry   geom.Geometry
+	Properties map[string]interface{}
+}
+
+// Decode converts every layer in the tile
+func Decode(tile *Tile) ([]*Layer, error) {
+	layers := make([]*Layer, len(tile.Layers))
+
+	for idx, l := range tile.Layers {
+		layer, err := DecodeLayer(l)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode layer: name = %s, error = %s", l.GetName(), err)
+		}
+
+		layers[idx] = layer
+	}
+
+	return layers, nil
+}
+
+// DecodeLayer converts a single tile layer
+func DecodeLayer(l *Tile_Layer) (*Layer, error) {
+	values := make([]interface{}, len(l.Values))
+	for idx, v := range l.Values {
+		value, err := DecodeValue(v)
+
+		if err != nil {
+			return nil, err
+		}
+
+		values[idx] = value
+	}
+
+	layer := &Layer{
+		Name:     l.GetName(),
+		Version:  l.GetVersion(),
+		Extent:   l.GetExtent(),
+		Features: make([]*Feature, 0, len(l.Features)),
+	}
+
+	for _, f := range l.Features {
+		if len(f.Tags)%2 != 0 {
+			return nil, ErrInvalidTags
+		}
+
+		props := make(map[string]interface{}, len(f.Tags)/2)
+		for t := 0; t < len(f.Tags); t += 2 {
+			k, v := f.Tags[t], f.Tags[t+1]
+
+			if int(k) >= len(l.Keys) || int(v) >= len(values) {
+				return nil, ErrInvalidTags
+			}
+
+			props[l.Keys[k]] = values[v]
+		}
+
+		g, err := DecodeGeometry(f.GetType(), f.Geometry)
+
+		// decoders may skip features of an unknown geometry type (section 4.3.5 of the specification)
+		if err == ErrUnsupportedGeometry {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		layer.Features = append(layer.Features, &Feature{
+			ID:         f.Id,
+			Type:       f.GetType(),
+			Geometry:   g,
+			Properties: props,
+		})
+	}
+
+	return layer, nil
+}
+
+// DecodeValue returns the Go value of a tile value
+func DecodeValue(v *Tile_Value) (interface{}, error) {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue, nil
+	case v.FloatValue != nil:
+		return *v.FloatValue, nil
+	case v.DoubleValue != nil:
+		return *v.DoubleValue, nil
+	case v.IntValue != nil:
+		return *v.IntValue, nil
+	case v.UintValue != nil:
+		return *v.UintValue, nil
+	case v.SintValue != nil:
+		return *v.SintValue, nil
+	case v.BoolValue != nil:
+		return *v.BoolValue, nil
+	}
+
+	return nil, ErrInvalidValue
+}
+
+// DecodeGeometry converts a command stream back into a geom type in tile coordinates. Single member multi types
+// are returned as their single counterpart. Polygons are split on exterior rings (positive area) as per section
+// 4.3.4.4 of the specification. Types other than points, lines and polygons return ErrUnsupportedGeometry.
+func DecodeGeometry(gtype Tile_GeomType, cmds []uint32) (geom.Geometry, error) {
+	switch gtype {
+	case Tile_POINT, Tile_LINESTRING, Tile_POLYGON:
+	default:
+		return nil, ErrUnsupportedGeometry
+	}
+
+	// only points may move to several positions in a single command
+	paths, err := readPaths(cmds, gtype != Tile_POINT)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch gtype {
+	case Tile_POINT:
+		points := []geom.Point{}
+		for _, path := range paths {
+			for _, p := range path.points {
+				points = append(points, geom.Point{Coordinate: coordinate(p)})
+			}
+		}
+
+		switch len(points) {
+		case 0:
+			return nil, ErrEmptyGeometry
+		case 1:
+			return &points[0], nil
+		}
+		return &geom.MultiPoint{Points: points}, nil
+
+	case Tile_LINESTRING:
+		lines := []geom.LineString{}
+		for _, path := range paths {
+			if len(path.points) < 2 {
+				return nil, ErrInvalidCommand
+			}
+			lines = append(lines, geom.LineString{Coordinates: coordinates(path.points)})
+		}
+
+		switch len(lines) {
+		case 0:
+			return nil, ErrEmptyGeometry
+		case 1:
+			return &lines[0], nil
+		}
+		return &geom.MultiLineString{LineStrings: lines}, nil
+
+	case Tile_POLYGON:
+		polygons := []geom.Polygon{}
+		for _, path := range paths {
+			if !path.closed || len(path.points) < 3 {
+				return nil, ErrInvalidCommand
+			}
+
+			area := Area(path.points)
+
+			if area == 0 {
+				continue
+			}
+
+			cs := append(coordinates(path.points), coordinate(path.points[0]))
+
+			if area > 0 {
+				polygons = append(polygons, geom.Polygon{})
+			} else if len(polygons) == 0 {
+				// interior ring without an exterior
+				return nil, ErrInvalidCommand
+			}
+
+			last := &polygons[len(polygons)-1]
+			last.Rings = append(last.Rings, geom.LinearRing{Coordinates: cs})
+		}
+
+		switch len(polygons) {
+		case 0:
+			return nil, ErrEmptyGeometry
+		case 1:
+			return &polygons[0], nil
+		}
+		return &geom.MultiPolygon{Polygons: polygons}, nil
+	}
+
+	return nil, ErrUnsupportedGeometry
+}
+
+// path is a run of points started by a MoveTo
+type path struct {
+	points [][2]int32
+	closed bool
+}
+
+// readPaths splits the command stream into paths, single requires every MoveTo to have a count of 1
+func readPaths(cmds []uint32, single bool) ([]*path, error) {
+	paths := []*path{}
+
+	var x, y int32
+	var current *path
+
+	for idx := 0; idx < len(cmds); {
+		id := cmds[idx] & 0x7
+		count := int(cmds[idx] >> 3)
+		idx++
+
+		switch id {
+		case cmdMoveTo, cmdLineTo:
+			if idx+count*2 > len(cmds) {
+				return nil, ErrInvalidCommand
+			}
+
+			if id == cmdLineTo && current == nil {
+				return nil, ErrInvalidCommand
+			}
+
+			if id == cmdMoveTo && single && count != 1 {
+				return nil, ErrInvalidCommand
+			}
+
+			for c := 0; c < count; c++ {
+				x += unzigzag(cmds[idx])
+				y += unzigzag(cmds[idx+1])
+				idx += 2
+
+				if id == cmdMoveTo {
+					current = &path{}
+					paths = append(paths, current)
+				}
+
+				current.points = append(current.points, [2]int32{x, y})
+			}
+
+		case cmdClosePath:
+			if current == nil || count != 1 {
+				return nil, ErrInvalidCommand
+			}
+			current.closed = true
+
+		default:
+			return nil, ErrInvalidCommand
+		}
+	}
+
+	return paths, nil
+}
+
+func coordinate(p [2]int32) geom.Coordinate {
+	return geom.Coordinate{float64(p[0]), float64(p[1])}
+}
+
+func coordinates(points [][2]int32) []geom.Coordinate {
+	cs := make([]geom.Coordinate, len(points))
+	for idx, p := range points {
+		cs[idx] = coordinate(p)
+	}
+	return cs
+}
+
+func unzigzag(v uint32) int32 {
+	return int32(v>>1) ^ -int32(v&1)
+}
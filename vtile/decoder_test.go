@@ -0,0 +1,132 @@
+package vtile
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/devork/geom"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeMultiPolygon(t *testing.T) {
+	cmds := []uint32{
+		9, 0, 0, 26, 20, 0, 0, 20, 19, 0, 15,
+		9, 22, 2, 26, 18, 0, 0, 18, 17, 0, 15,
+		9, 4, 13, 26, 0, 8, 8, 0, 0, 7, 15,
+	}
+
+	g, err := DecodeGeometry(Tile_POLYGON, cmds)
+	require.NoError(t, err)
+
+	mp, ok := g.(*geom.MultiPolygon)
+	require.True(t, ok)
+	require.Equal(t, 2, len(mp.Polygons))
+	require.Equal(t, 1, len(mp.Polygons[0].Rings))
+	require.Equal(t, 2, len(mp.Polygons[1].Rings))
+	require.Equal(t, []geom.Coordinate{{13, 13}, {13, 17}, {17, 17}, {17, 13}, {13, 13}}, mp.Polygons[1].Rings[1].Coordinates)
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	// LineTo without a MoveTo
+	_, err := DecodeGeometry(Tile_LINESTRING, []uint32{18, 0, 16, 16, 0})
+	require.Equal(t, ErrInvalidCommand, err)
+
+	// truncated parameters
+	_, err = DecodeGeometry(Tile_POINT, []uint32{17, 10, 14, 3})
+	require.Equal(t, ErrInvalidCommand, err)
+
+	// MoveTo of two positions is only valid for points
+	cmds := []uint32{17, 0, 0, 4, 4}
+	_, err = DecodeGeometry(Tile_POINT, cmds)
+	require.NoError(t, err)
+
+	_, err = DecodeGeometry(Tile_LINESTRING, append(cmds, 10, 2, 2))
+	require.Equal(t, ErrInvalidCommand, err)
+
+	_, err = DecodeGeometry(Tile_UNKNOWN, cmds)
+	require.Equal(t, ErrUnsupportedGeometry, err)
+}
+
+func TestDecodeSkipsUnknown(t *testing.T) {
+	unknown := Tile_UNKNOWN
+	point := Tile_POINT
+
+	layer, err := DecodeLayer(&Tile_Layer{
+		Features: []*Tile_Feature{
+			{Type: &unknown, Geometry: []uint32{9, 2, 2}},
+			{Type: &point, Geometry: []uint32{9, 4, 4}},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(layer.Features))
+	require.Equal(t, Tile_POINT, layer.Features[0].Type)
+}
+
+func TestDecodeRoundTrip(t *testing.T) {
+	mls := &geom.MultiLineString{
+		LineStrings: []geom.LineString{
+			{Coordinates: []geom.Coordinate{{2, 2}, {2, 10}, {10, 10}}},
+			{Coordinates: []geom.Coordinate{{1, 1}, {3, 5}}},
+		},
+	}
+
+	feature, err := NewEncoder(Identity).Encode(mls)
+	require.NoError(t, err)
+
+	id := uint64(7)
+	name := "road"
+	extent := uint32(4096)
+	class := "A Road"
+	feature.Id = &id
+	feature.Tags = []uint32{0, 0}
+
+	tile := &Tile{
+		Layers: []*Tile_Layer{{
+			Name:     &name,
+			Extent:   &extent,
+			Keys:     []string{"class"},
+			Values:   []*Tile_Value{{StringValue: &class}},
+			Features: []*Tile_Feature{feature},
+		}},
+	}
+
+	layers, err := Decode(tile)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(layers))
+	require.Equal(t, "road", layers[0].Name)
+	require.Equal(t, 1, len(layers[0].Features))
+
+	f := layers[0].Features[0]
+	require.Equal(t, id, *f.ID)
+	require.Equal(t, map[string]interface{}{"class": "A Road"}, f.Properties)
+	require.Equal(t, mls.LineStrings, f.Geometry.(*geom.MultiLineString).LineStrings)
+}
+
+func TestGeoJSON(t *testing.T) {
+	layer := &Layer{
+		Name:   "namedplace",
+		Extent: 4096,
+		Features: []*Feature{{
+			Type:       Tile_POINT,
+			Geometry:   &geom.Point{Coordinate: geom.Coordinate{2048, 2048}},
+			Properties: map[string]interface{}{"name": "Null Island"},
+		}},
+	}
+
+	data, err := layer.GeoJSON(0, 0, 0)
+	require.NoError(t, err)
+
+	var fc struct {
+		Features []struct {
+			Geometry struct {
+				Type        string    `json:"type"`
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+
+	require.NoError(t, json.Unmarshal(data, &fc))
+	require.Equal(t, "Point", fc.Features[0].Geometry.Type)
+	require.InDelta(t, 0, fc.Features[0].Geometry.Coordinates[0], 1e-9)
+	require.InDelta(t, 0, fc.Features[0].Geometry.Coordinates[1], 1e-9)
+}
@@ -0,0 +1,216 @@
+package vtile
+
+import (
+	"fmt"
+	"math"
+	"time"
+	"unicode/utf8"
+)
+
+// Dictionary builds the keys and values of a layer (see section 4.4 of the specification). Keys and values are only
+// added as features are tagged, so the layer holds no unused entries.
+//
+// Values are canonicalised before being added so the same value is only stored once, whatever Go type it was read as:
+// integers are stored as unsigned where possible (negative values as zigzag encoded sint, as done by ST_AsMVT),
+// floats are rounded to Precision decimal places (when set, up to MaxPrecision) and strings are cut to MaxLength
+// characters (when set). A Dictionary is not safe for concurrent use.
+type Dictionary struct {
+	Precision *int
+	MaxLength int
+
+	keys   []string
+	kindex map[string]uint32
+	values []*Tile_Value
+	vindex map[value]uint32
+}
+
+// MaxPrecision is the most decimal places floats are rounded to, beyond which a float64 holds no more digits
+const MaxPrecision = 15
+
+// value is the comparable form of a tile value
+type value struct {
+	kind int
+	s    string
+	i    int64
+	u    uint64
+	f    float64
+	b    bool
+}
+
+const (
+	kindString = iota
+	kindFloat
+	kindDouble
+	kindUint
+	kindSint
+	kindBool
+)
+
+// NewDictionary creates an empty dictionary
+func NewDictionary() *Dictionary {
+	return &Dictionary{kindex: map[string]uint32{}, vindex: map[value]uint32{}}
+}
+
+// Tag appends the key/value to the tags of a feature, adding them to the dictionary if needed. Nil values are
+// skipped, as are floats which are not numbers (NaN or infinite).
+func (d *Dictionary) Tag(tags []uint32, key string, v interface{}) []uint32 {
+	cv, ok := d.canonical(v)
+
+	if !ok {
+		return tags
+	}
+
+	k, ok := d.kindex[key]
+	if !ok {
+		k = uint32(len(d.keys))
+		d.keys = append(d.keys, key)
+		d.kindex[key] = k
+	}
+
+	idx, ok := d.vindex[cv]
+	if !ok {
+		idx = uint32(len(d.values))
+		d.values = append(d.values, cv.tile())
+		d.vindex[cv] = idx
+	}
+
+	return append(tags, k, idx)
+}
+
+// Keys returns the keys in the order they were added
+func (d *Dictionary) Keys() []string {
+	return d.keys
+}
+
+// Values returns the values in the order they were added
+func (d *Dictionary) Values() []*Tile_Value {
+	return d.values
+}
+
+func (d *Dictionary) canonical(v interface{}) (value, bool) {
+	switch v := v.(type) {
+	case nil:
+		return value{}, false
+	case string:
+		return d.str(v), true
+	case bool:
+		return value{kind: kindBool, b: v}, true
+	case float32:
+		return d.float(float64(v))
+	case float64:
+		return d.float(v)
+	case int:
+		return integer(int64(v)), true
+	case int8:
+		return integer(int64(v)), true
+	case int16:
+		return integer(int64(v)), true
+	case int32:
+		return integer(int64(v)), true
+	case int64:
+		return integer(v), true
+	case uint:
+		return value{kind: kindUint, u: uint64(v)}, true
+	case uint8:
+		return value{kind: kindUint, u: uint64(v)}, true
+	case uint16:
+		return value{kind: kindUint, u: uint64(v)}, true
+	case uint32:
+		return value{kind: kindUint, u: uint64(v)}, true
+	case uint64:
+		return value{kind: kindUint, u: v}, true
+	case time.Time:
+		return d.str(v.Format(time.RFC3339)), true
+	}
+
+	return d.str(fmt.Sprint(v)), true
+}
+
+func (d *Dictionary) str(s string) value {
+	if d.MaxLength > 0 && utf8.RuneCountInString(s) > d.MaxLength {
+		// cut on a character boundary
+		n := 0
+		for idx := range s {
+			if n == d.MaxLength {
+				s = s[:idx]
+				break
+			}
+			n++
+		}
+	}
+
+	return value{kind: kindString, s: s}
+}
+
+// float rounds the value to the dictionary precision. Values which survive the trip through a float32 (at the
+// precision, or exactly without one) are stored as the smaller float type, so a float32 and float64 of the same value
+// share an entry.
+func (d *Dictionary) float(f float64) (value, bool) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return value{}, false
+	}
+
+	if d.Precision == nil {
+		if float64(float32(f)) == f {
+			return value{kind: kindFloat, f: f}, true
+		}
+		return value{kind: kindDouble, f: f}, true
+	}
+
+	precision := *d.Precision
+	if precision < 0 {
+		precision = 0
+	} else if precision > MaxPrecision {
+		precision = MaxPrecision
+	}
+
+	f = round(f, precision)
+
+	if round(float64(float32(f)), precision) == f {
+		return value{kind: kindFloat, f: float64(float32(f))}, true
+	}
+
+	return value{kind: kindDouble, f: f}, true
+}
+
+// round rounds the value to the decimal places, leaving values too large to have any at that precision as they are
+func round(f float64, precision int) float64 {
+	p := math.Pow10(precision)
+
+	r := math.Round(f*p) / p
+	if math.IsInf(r, 0) || math.IsNaN(r) {
+		return f
+	}
+
+	return r
+}
+
+func integer(i int64) value {
+	if i < 0 {
+		return value{kind: kindSint, i: i}
+	}
+	return value{kind: kindUint, u: uint64(i)}
+}
+
+func (v value) tile() *Tile_Value {
+	switch v.kind {
+	case kindFloat:
+		f := float32(v.f)
+		return &Tile_Value{FloatValue: &f}
+	case kindDouble:
+		f := v.f
+		return &Tile_Value{DoubleValue: &f}
+	case kindUint:
+		u := v.u
+		return &Tile_Value{UintValue: &u}
+	case kindSint:
+		i := v.i
+		return &Tile_Value{SintValue: &i}
+	case kindBool:
+		b := v.b
+		return &Tile_Value{BoolValue: &b}
+	}
+
+	s := v.s
+	return &Tile_Value{StringValue: &s}
+}
@@ -0,0 +1,101 @@
+package vtile
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDictionaryNumerics(t *testing.T) {
+	d := NewDictionary()
+
+	var tags []uint32
+	tags = d.Tag(tags, "a", int32(1))
+	tags = d.Tag(tags, "b", int64(1))
+	tags = d.Tag(tags, "c", uint(1))
+	tags = d.Tag(tags, "d", "1")
+	tags = d.Tag(tags, "e", int64(-1))
+
+	require.Equal(t, []uint32{0, 0, 1, 0, 2, 0, 3, 1, 4, 2}, tags)
+	require.Equal(t, []string{"a", "b", "c", "d", "e"}, d.Keys())
+
+	one := uint64(1)
+	str := "1"
+	minus := int64(-1)
+	require.Equal(t, []*Tile_Value{{UintValue: &one}, {StringValue: &str}, {SintValue: &minus}}, d.Values())
+}
+
+func TestDictionarySkips(t *testing.T) {
+	d := NewDictionary()
+
+	var tags []uint32
+	tags = d.Tag(tags, "a", nil)
+	tags = d.Tag(tags, "b", math.NaN())
+	tags = d.Tag(tags, "c", math.Inf(1))
+
+	require.Empty(t, tags)
+	require.Empty(t, d.Keys())
+	require.Empty(t, d.Values())
+}
+
+func TestDictionaryPrecision(t *testing.T) {
+	d := NewDictionary()
+
+	tags := d.Tag(nil, "a", 1.23456789)
+	tags = d.Tag(tags, "b", float32(1.5))
+	tags = d.Tag(tags, "c", 1.5)
+
+	// a float64 which a float holds exactly shares the entry of the float
+	require.Equal(t, []uint32{0, 0, 1, 1, 2, 1}, tags)
+
+	f := 1.23456789
+	f32 := float32(1.5)
+	require.Equal(t, []*Tile_Value{{DoubleValue: &f}, {FloatValue: &f32}}, d.Values())
+
+	precision := 2
+	d = NewDictionary()
+	d.Precision = &precision
+
+	tags = d.Tag(nil, "a", 1.23456789)
+	tags = d.Tag(tags, "b", 1.234)
+	tags = d.Tag(tags, "c", 1.5)
+
+	// both round to the same value, and fit into a float
+	require.Equal(t, []uint32{0, 0, 1, 0, 2, 1}, tags)
+
+	r := float32(1.23)
+	require.Equal(t, []*Tile_Value{{FloatValue: &r}, {FloatValue: &f32}}, d.Values())
+
+	// too big for a float at the precision
+	d.Tag(nil, "d", 123456789.25)
+	big := 123456789.25
+	require.Equal(t, &Tile_Value{DoubleValue: &big}, d.Values()[2])
+
+	// precisions past what a float holds are clamped, so values are never lost to overflow
+	precision = 400
+	d = NewDictionary()
+	d.Precision = &precision
+
+	tags = d.Tag(nil, "a", 1.25)
+	tags = d.Tag(tags, "b", 1.25)
+	tags = d.Tag(tags, "c", 1e300)
+	require.Equal(t, []uint32{0, 0, 1, 0, 2, 1}, tags)
+
+	huge := 1e300
+	require.Equal(t, &Tile_Value{DoubleValue: &huge}, d.Values()[1])
+}
+
+func TestDictionaryStrings(t *testing.T) {
+	d := NewDictionary()
+	d.MaxLength = 4
+
+	d.Tag(nil, "a", "Gällivare")
+	d.Tag(nil, "b", "Gäll")
+	d.Tag(nil, "c", "abc")
+	d.Tag(nil, "d", time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	s1, s2, s3 := "Gäll", "abc", "2018"
+	require.Equal(t, []*Tile_Value{{StringValue: &s1}, {StringValue: &s2}, {StringValue: &s3}}, d.Values())
+}
@@ -0,0 +1,252 @@
+package vtile
+
+import (
+	"errors"
+	"math"
+
+	"github.com/devork/geom"
+	"github.com/devork/grava/geo"
+)
+
+// Command IDs as defined in section 4.3.1 of the specification
+const (
+	cmdMoveTo    uint32 = 1
+	cmdLineTo    uint32 = 2
+	cmdClosePath uint32 = 7
+)
+
+// Common errors
+var (
+	ErrEmptyGeometry       = errors.New("geometry is empty once encoded")
+	ErrUnsupportedGeometry = errors.New("unsupported geometry type")
+)
+
+// Transform converts source coordinates into integer tile coordinates. Coordinates are offset by the origin, scaled
+// and then floored.
+type Transform struct {
+	Minx, Miny     float64
+	ScaleX, ScaleY float64
+}
+
+// Identity is used for geometries which are already in tile coordinates
+var Identity = Transform{ScaleX: 1, ScaleY: 1}
+
+// NewTransform creates the transform for a tile of the given extent covering box. For boxes created through
+// geo.NewBBox the y axis is inverted, giving the screen space (positive 'y' downwards) tile coordinates MVT expects.
+func NewTransform(box *geo.BBox, extent uint32) Transform {
+	return Transform{
+		Minx:   box.Minx,
+		Miny:   box.Miny,
+		ScaleX: float64(extent) / (box.Maxx - box.Minx),
+		ScaleY: float64(extent) / (box.Maxy - box.Miny),
+	}
+}
+
+// Apply transforms a single coordinate
+func (t Transform) Apply(c geom.Coordinate) (int32, int32) {
+	return int32(math.Floor((c[0] - t.Minx) * t.ScaleX)), int32(math.Floor((c[1] - t.Miny) * t.ScaleY))
+}
+
+// Encoder writes the command stream for a feature geometry (see section 4.3 of the specification). The encoder
+// keeps track of the cursor so parameters are written as zigzag encoded deltas from the previous position.
+//
+// The individual command functions can be used directly to build arbitrary geometries, or Encode can be used to
+// build a complete feature from a geom type. An Encoder is not safe for concurrent use.
+type Encoder struct {
+	t    Transform
+	cmds []uint32
+	x, y int32
+}
+
+// NewEncoder creates an encoder applying the given transform to all geom coordinates
+func NewEncoder(t Transform) *Encoder {
+	return &Encoder{t: t}
+}
+
+// Reset clears the command stream and moves the cursor back to the origin
+func (e *Encoder) Reset() {
+	e.cmds = nil
+	e.x = 0
+	e.y = 0
+}
+
+// Geometry returns the commands written since the last reset
+func (e *Encoder) Geometry() []uint32 {
+	return e.cmds
+}
+
+// MoveTo writes a MoveTo command for the given tile coordinates. Multiple points are written with a single command
+// as used by multipoint geometries.
+func (e *Encoder) MoveTo(points ...[2]int32) {
+	e.write(cmdMoveTo, points)
+}
+
+// LineTo writes a LineTo command for the given tile coordinates
+func (e *Encoder) LineTo(points ...[2]int32) {
+	e.write(cmdLineTo, points)
+}
+
+// ClosePath writes a ClosePath command, this does not move the cursor
+func (e *Encoder) ClosePath() {
+	e.cmds = append(e.cmds, command(cmdClosePath, 1))
+}
+
+func (e *Encoder) write(id uint32, points [][2]int32) {
+	if len(points) == 0 {
+		return
+	}
+
+	e.cmds = append(e.cmds, command(id, uint32(len(points))))
+
+	for _, p := range points {
+		e.cmds = append(e.cmds, zigzag(p[0]-e.x), zigzag(p[1]-e.y))
+		e.x = p[0]
+		e.y = p[1]
+	}
+}
+
+// Encode resets the encoder and writes the given geometry into a new feature. ErrEmptyGeometry is returned if
+// nothing remains of the geometry once it is in tile coordinates (e.g. all polygon rings have collapsed to zero area).
+func (e *Encoder) Encode(g geom.Geometry) (*Tile_Feature, error) {
+	e.Reset()
+
+	var gtype Tile_GeomType
+
+	switch g := g.(type) {
+	case *geom.Point:
+		gtype = Tile_POINT
+		e.MoveTo(e.coord(g.Coordinate))
+	case *geom.MultiPoint:
+		gtype = Tile_POINT
+		points := make([][2]int32, len(g.Points))
+		for idx, p := range g.Points {
+			points[idx] = e.coord(p.Coordinate)
+		}
+		e.MoveTo(points...)
+	case *geom.LineString:
+		gtype = Tile_LINESTRING
+		e.line(g.Coordinates)
+	case *geom.MultiLineString:
+		gtype = Tile_LINESTRING
+		for _, ls := range g.LineStrings {
+			e.line(ls.Coordinates)
+		}
+	case *geom.Polygon:
+		gtype = Tile_POLYGON
+		e.polygon(g)
+	case *geom.MultiPolygon:
+		gtype = Tile_POLYGON
+		// each polygon starts with a (clockwise) exterior ring, which is what tells a decoder a new polygon has begun
+		for idx := range g.Polygons {
+			e.polygon(&g.Polygons[idx])
+		}
+	default:
+		return nil, ErrUnsupportedGeometry
+	}
+
+	if len(e.cmds) == 0 {
+		return nil, ErrEmptyGeometry
+	}
+
+	return &Tile_Feature{Type: &gtype, Geometry: e.cmds}, nil
+}
+
+func (e *Encoder) coord(c geom.Coordinate) [2]int32 {
+	x, y := e.t.Apply(c)
+	return [2]int32{x, y}
+}
+
+func (e *Encoder) coords(cs []geom.Coordinate) [][2]int32 {
+	points := make([][2]int32, len(cs))
+	for idx, c := range cs {
+		points[idx] = e.coord(c)
+	}
+	return points
+}
+
+// line writes a single line. Repeated points (which quantization tends to create) are removed so there are no
+// zero length LineTo commands, and lines which collapse to a single point are dropped.
+func (e *Encoder) line(cs []geom.Coordinate) {
+	points := dedupe(e.coords(cs))
+
+	if len(points) < 2 {
+		return
+	}
+
+	e.MoveTo(points[0])
+	e.LineTo(points[1:]...)
+}
+
+// polygon writes a single polygon. Winding order is enforced after quantization as per the 2.1 spec: the exterior
+// ring must have a positive area in tile coordinates (clockwise, y pointing down) and interior rings a negative
+// area. Repeated points are removed, and rings with fewer than three distinct points or which collapse to zero area
+// are dropped - if that is the exterior ring, the whole polygon goes.
+func (e *Encoder) polygon(g *geom.Polygon) {
+	for idx, ring := range g.Rings {
+		points := dedupe(e.coords(ring.Coordinates))
+
+		// the closing point is implied by ClosePath
+		for len(points) > 1 && points[len(points)-1] == points[0] {
+			points = points[:len(points)-1]
+		}
+
+		area := int64(0)
+		if len(points) >= 3 {
+			area = Area(points)
+		}
+
+		if area == 0 {
+			if idx == 0 {
+				return
+			}
+			continue
+		}
+
+		// exterior positive, interior negative
+		if (idx == 0) != (area > 0) {
+			reverse(points)
+		}
+
+		e.MoveTo(points[0])
+		e.LineTo(points[1:]...)
+		e.ClosePath()
+	}
+}
+
+// Area returns twice the signed area of an open ring in tile coordinates using the surveyor's formula. Exterior
+// rings are positive, interior rings negative.
+func Area(ring [][2]int32) int64 {
+	var sum int64
+	for idx := range ring {
+		a := ring[idx]
+		b := ring[(idx+1)%len(ring)]
+		sum += int64(a[0])*int64(b[1]) - int64(b[0])*int64(a[1])
+	}
+	return sum
+}
+
+// dedupe removes consecutive repeated points
+func dedupe(points [][2]int32) [][2]int32 {
+	out := points[:0]
+	for _, p := range points {
+		if len(out) > 0 && out[len(out)-1] == p {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func reverse(ring [][2]int32) {
+	for i, j := 0, len(ring)-1; i < j; i, j = i+1, j-1 {
+		ring[i], ring[j] = ring[j], ring[i]
+	}
+}
+
+func command(id, count uint32) uint32 {
+	return id&0x7 | count<<3
+}
+
+func zigzag(v int32) uint32 {
+	return uint32(v<<1 ^ v>>31)
+}
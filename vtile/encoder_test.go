@@ -0,0 +1,180 @@
+package vtile
+
+import (
+	"testing"
+
+	"github.com/devork/geom"
+	"github.com/devork/grava/geo"
+	"github.com/stretchr/testify/require"
+)
+
+// https://github.com/mapbox/vector-tile-spec/tree/master/2.1#4351-example-point
+func TestEncodePoint(t *testing.T) {
+	feature, err := NewEncoder(Identity).Encode(&geom.Point{Coordinate: geom.Coordinate{25, 17}})
+	require.NoError(t, err)
+	require.Equal(t, Tile_POINT, feature.GetType())
+	require.Equal(t, []uint32{9, 50, 34}, feature.Geometry)
+}
+
+// https://github.com/mapbox/vector-tile-spec/tree/master/2.1#4352-example-multi-point
+func TestEncodeMultiPoint(t *testing.T) {
+	mp := &geom.MultiPoint{
+		Points: []geom.Point{
+			{Coordinate: geom.Coordinate{5, 7}},
+			{Coordinate: geom.Coordinate{3, 2}},
+		},
+	}
+
+	feature, err := NewEncoder(Identity).Encode(mp)
+	require.NoError(t, err)
+	require.Equal(t, Tile_POINT, feature.GetType())
+	require.Equal(t, []uint32{17, 10, 14, 3, 9}, feature.Geometry)
+}
+
+// https://github.com/mapbox/vector-tile-spec/tree/master/2.1#4353-example-linestring
+func TestEncodeLineString(t *testing.T) {
+	ls := &geom.LineString{Coordinates: []geom.Coordinate{{2, 2}, {2, 10}, {10, 10}}}
+
+	feature, err := NewEncoder(Identity).Encode(ls)
+	require.NoError(t, err)
+	require.Equal(t, Tile_LINESTRING, feature.GetType())
+	require.Equal(t, []uint32{9, 4, 4, 18, 0, 16, 16, 0}, feature.Geometry)
+}
+
+// https://github.com/mapbox/vector-tile-spec/tree/master/2.1#4354-example-multi-linestring
+func TestEncodeMultiLineString(t *testing.T) {
+	mls := &geom.MultiLineString{
+		LineStrings: []geom.LineString{
+			{Coordinates: []geom.Coordinate{{2, 2}, {2, 10}, {10, 10}}},
+			{Coordinates: []geom.Coordinate{{1, 1}, {3, 5}}},
+		},
+	}
+
+	feature, err := NewEncoder(Identity).Encode(mls)
+	require.NoError(t, err)
+	require.Equal(t, Tile_LINESTRING, feature.GetType())
+	require.Equal(t, []uint32{9, 4, 4, 18, 0, 16, 16, 0, 9, 17, 17, 10, 4, 8}, feature.Geometry)
+}
+
+// https://github.com/mapbox/vector-tile-spec/tree/master/2.1#4355-example-polygon
+func TestEncodePolygon(t *testing.T) {
+	polygon := &geom.Polygon{
+		Hdr: geom.Hdr{Dim: geom.XY, Srid: 27700},
+		Rings: []geom.LinearRing{{
+			Coordinates: []geom.Coordinate{{3, 6}, {8, 12}, {20, 34}, {3, 6}},
+		}},
+	}
+
+	feature, err := NewEncoder(Identity).Encode(polygon)
+	require.NoError(t, err)
+	require.Equal(t, Tile_POLYGON, feature.GetType())
+	require.Equal(t, []uint32{9, 6, 12, 18, 10, 12, 24, 44, 15}, feature.Geometry)
+}
+
+// https://github.com/mapbox/vector-tile-spec/tree/master/2.1#4356-example-multi-polygon
+func TestEncodeMultiPolygon(t *testing.T) {
+	mp := &geom.MultiPolygon{
+		Polygons: []geom.Polygon{
+			{Rings: []geom.LinearRing{
+				{Coordinates: []geom.Coordinate{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}},
+			}},
+			{Rings: []geom.LinearRing{
+				{Coordinates: []geom.Coordinate{{11, 11}, {20, 11}, {20, 20}, {11, 20}, {11, 11}}},
+				{Coordinates: []geom.Coordinate{{13, 13}, {13, 17}, {17, 17}, {17, 13}, {13, 13}}},
+			}},
+		},
+	}
+
+	feature, err := NewEncoder(Identity).Encode(mp)
+	require.NoError(t, err)
+	require.Equal(t, Tile_POLYGON, feature.GetType())
+
+	var expected = []uint32{
+		9, 0, 0, 26, 20, 0, 0, 20, 19, 0, 15,
+		9, 22, 2, 26, 18, 0, 0, 18, 17, 0, 15,
+		9, 4, 13, 26, 0, 8, 8, 0, 0, 7, 15,
+	}
+	require.Equal(t, expected, feature.Geometry)
+}
+
+func TestEncodePolygonWinding(t *testing.T) {
+	// anti-clockwise exterior (in tile coords) with a clockwise hole: both must be reversed
+	polygon := &geom.Polygon{
+		Rings: []geom.LinearRing{
+			{Coordinates: []geom.Coordinate{{0, 0}, {0, 10}, {10, 10}, {10, 0}, {0, 0}}},
+			{Coordinates: []geom.Coordinate{{2, 2}, {4, 2}, {4, 4}, {2, 4}, {2, 2}}},
+		},
+	}
+
+	feature, err := NewEncoder(Identity).Encode(polygon)
+	require.NoError(t, err)
+
+	var expected = []uint32{
+		9, 20, 0, 26, 0, 20, 19, 0, 0, 19, 15,
+		9, 4, 8, 26, 4, 0, 0, 3, 3, 0, 15,
+	}
+	require.Equal(t, expected, feature.Geometry)
+}
+
+func TestEncodePolygonCollapsed(t *testing.T) {
+	polygon := &geom.Polygon{
+		Rings: []geom.LinearRing{{
+			Coordinates: []geom.Coordinate{{0, 0}, {0.2, 0.2}, {0.4, 0.1}, {0, 0}},
+		}},
+	}
+
+	_, err := NewEncoder(Identity).Encode(polygon)
+	require.Equal(t, ErrEmptyGeometry, err)
+}
+
+func TestEncodeRepeatedPoints(t *testing.T) {
+	// quantizes to (2,2) (2,2) (2,10) (2,10) (10,10)
+	ls := &geom.LineString{Coordinates: []geom.Coordinate{{2, 2}, {2.5, 2.9}, {2, 10}, {2.1, 10}, {10, 10}}}
+
+	feature, err := NewEncoder(Identity).Encode(ls)
+	require.NoError(t, err)
+	require.Equal(t, []uint32{9, 4, 4, 18, 0, 16, 16, 0}, feature.Geometry)
+
+	// collapses to a single pixel
+	_, err = NewEncoder(Identity).Encode(&geom.LineString{Coordinates: []geom.Coordinate{{2, 2}, {2.5, 2.5}, {2.9, 2.1}}})
+	require.Equal(t, ErrEmptyGeometry, err)
+
+	// a hole with only two distinct points is dropped, leaving the exterior (with its repeated point removed)
+	polygon := &geom.Polygon{
+		Rings: []geom.LinearRing{
+			{Coordinates: []geom.Coordinate{{0, 0}, {10, 0}, {10, 0.5}, {10, 10}, {0, 10}, {0, 0}}},
+			{Coordinates: []geom.Coordinate{{2, 2}, {4, 4}, {4.5, 4.5}, {2, 2}}},
+		},
+	}
+
+	feature, err = NewEncoder(Identity).Encode(polygon)
+	require.NoError(t, err)
+	require.Equal(t, []uint32{9, 0, 0, 26, 20, 0, 0, 20, 19, 0, 15}, feature.Geometry)
+	require.NoError(t, Validate(feature))
+}
+
+func TestEncodeUnsupported(t *testing.T) {
+	_, err := NewEncoder(Identity).Encode(&geom.GeometryCollection{})
+	require.Equal(t, ErrUnsupportedGeometry, err)
+}
+
+func TestEncodeTransform(t *testing.T) {
+	// inverted 'y' as produced by geo.NewBBox
+	box := &geo.BBox{Minx: 100, Miny: 200, Maxx: 200, Maxy: 100}
+
+	feature, err := NewEncoder(NewTransform(box, 4096)).Encode(&geom.Point{Coordinate: geom.Coordinate{125, 175}})
+	require.NoError(t, err)
+	require.Equal(t, []uint32{9, 2048, 2048}, feature.Geometry)
+}
+
+func TestEncoderCommands(t *testing.T) {
+	enc := NewEncoder(Identity)
+	enc.MoveTo([2]int32{3, 6})
+	enc.LineTo([2]int32{8, 12}, [2]int32{20, 34})
+	enc.ClosePath()
+
+	require.Equal(t, []uint32{9, 6, 12, 18, 10, 12, 24, 44, 15}, enc.Geometry())
+
+	enc.Reset()
+	require.Empty(t, enc.Geometry())
+}
@@ -0,0 +1,150 @@
+package vtile
+
+import (
+	"encoding/json"
+
+	"github.com/devork/geom"
+	"github.com/devork/grava/geo"
+)
+
+// ToLonLat converts a geometry in tile coordinates (for a tile of the given extent at z/x/y) into
+// longitude/latitude. Geometry types which cannot appear in a tile are returned unchanged.
+func ToLonLat(g geom.Geometry, extent uint32, z, x, y int) geom.Geometry {
+	tx := func(c geom.Coordinate) geom.Coordinate {
+		long, lat := geo.LonLat(float64(x)+c[0]/float64(extent), float64(y)+c[1]/float64(extent), z)
+		return geom.Coordinate{long, lat}
+	}
+
+	txs := func(cs []geom.Coordinate) []geom.Coordinate {
+		out := make([]geom.Coordinate, len(cs))
+		for idx, c := range cs {
+			out[idx] = tx(c)
+		}
+		return out
+	}
+
+	txp := func(p *geom.Polygon) geom.Polygon {
+		out := geom.Polygon{Hdr: geom.Hdr{Dim: geom.XY, Srid: 4326}, Rings: make([]geom.LinearRing, len(p.Rings))}
+		for idx, ring := range p.Rings {
+			out.Rings[idx] = geom.LinearRing{Coordinates: txs(ring.Coordinates)}
+		}
+		return out
+	}
+
+	hdr := geom.Hdr{Dim: geom.XY, Srid: 4326}
+
+	switch g := g.(type) {
+	case *geom.Point:
+		return &geom.Point{Hdr: hdr, Coordinate: tx(g.Coordinate)}
+	case *geom.MultiPoint:
+		out := &geom.MultiPoint{Hdr: hdr, Points: make([]geom.Point, len(g.Points))}
+		for idx, p := range g.Points {
+			out.Points[idx] = geom.Point{Hdr: hdr, Coordinate: tx(p.Coordinate)}
+		}
+		return out
+	case *geom.LineString:
+		return &geom.LineString{Hdr: hdr, Coordinates: txs(g.Coordinates)}
+	case *geom.MultiLineString:
+		out := &geom.MultiLineString{Hdr: hdr, LineStrings: make([]geom.LineString, len(g.LineStrings))}
+		for idx, ls := range g.LineStrings {
+			out.LineStrings[idx] = geom.LineString{Hdr: hdr, Coordinates: txs(ls.Coordinates)}
+		}
+		return out
+	case *geom.Polygon:
+		p := txp(g)
+		return &p
+	case *geom.MultiPolygon:
+		out := &geom.MultiPolygon{Hdr: hdr, Polygons: make([]geom.Polygon, len(g.Polygons))}
+		for idx := range g.Polygons {
+			out.Polygons[idx] = txp(&g.Polygons[idx])
+		}
+		return out
+	}
+
+	return g
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	ID         *uint64                `json:"id,omitempty"`
+	Geometry   *geoJSONGeometry       `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string            `json:"type"`
+	Features []*geoJSONFeature `json:"features"`
+}
+
+// GeoJSON converts the layer into a GeoJSON FeatureCollection with longitude/latitude coordinates, z/x/y being the
+// address of the tile the layer was decoded from.
+func (l *Layer) GeoJSON(z, x, y int) ([]byte, error) {
+	fc := geoJSONFeatureCollection{Type: "FeatureCollection", Features: make([]*geoJSONFeature, len(l.Features))}
+
+	for idx, f := range l.Features {
+		fc.Features[idx] = &geoJSONFeature{
+			Type:       "Feature",
+			ID:         f.ID,
+			Geometry:   toGeoJSON(ToLonLat(f.Geometry, l.Extent, z, x, y)),
+			Properties: f.Properties,
+		}
+	}
+
+	return json.Marshal(fc)
+}
+
+func toGeoJSON(g geom.Geometry) *geoJSONGeometry {
+	position := func(c geom.Coordinate) []float64 {
+		return []float64{c[0], c[1]}
+	}
+
+	positions := func(cs []geom.Coordinate) [][]float64 {
+		out := make([][]float64, len(cs))
+		for idx, c := range cs {
+			out[idx] = position(c)
+		}
+		return out
+	}
+
+	rings := func(p *geom.Polygon) [][][]float64 {
+		out := make([][][]float64, len(p.Rings))
+		for idx, ring := range p.Rings {
+			out[idx] = positions(ring.Coordinates)
+		}
+		return out
+	}
+
+	switch g := g.(type) {
+	case *geom.Point:
+		return &geoJSONGeometry{"Point", position(g.Coordinate)}
+	case *geom.MultiPoint:
+		coords := make([][]float64, len(g.Points))
+		for idx, p := range g.Points {
+			coords[idx] = position(p.Coordinate)
+		}
+		return &geoJSONGeometry{"MultiPoint", coords}
+	case *geom.LineString:
+		return &geoJSONGeometry{"LineString", positions(g.Coordinates)}
+	case *geom.MultiLineString:
+		coords := make([][][]float64, len(g.LineStrings))
+		for idx, ls := range g.LineStrings {
+			coords[idx] = positions(ls.Coordinates)
+		}
+		return &geoJSONGeometry{"MultiLineString", coords}
+	case *geom.Polygon:
+		return &geoJSONGeometry{"Polygon", rings(g)}
+	case *geom.MultiPolygon:
+		coords := make([][][][]float64, len(g.Polygons))
+		for idx := range g.Polygons {
+			coords[idx] = rings(&g.Polygons[idx])
+		}
+		return &geoJSONGeometry{"MultiPolygon", coords}
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package vtile
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidGeometry is returned for geometries which are well formed commands but not a legal geometry of the
+// feature's type
+var ErrInvalidGeometry = errors.New("invalid feature geometry")
+
+// ValidateLayer checks the layer against the 2.1 specification: the layer must be named, every feature valid and every
+// tag must refer to a key and a (typed) value of the layer.
+func ValidateLayer(l *Tile_Layer) error {
+	if l.GetName() == "" {
+		return errors.New("layer has no name")
+	}
+
+	if l.GetVersion() != 2 {
+		return fmt.Errorf("unsupported layer version: layer = %s, version = %d", l.GetName(), l.GetVersion())
+	}
+
+	if l.GetExtent() == 0 {
+		return fmt.Errorf("layer has no extent: layer = %s", l.GetName())
+	}
+
+	for idx, v := range l.Values {
+		if _, err := DecodeValue(v); err != nil {
+			return fmt.Errorf("invalid value: layer = %s, value = %d, error = %s", l.GetName(), idx, err)
+		}
+	}
+
+	for idx, f := range l.Features {
+		if err := Validate(f); err != nil {
+			return fmt.Errorf("invalid feature: layer = %s, feature = %d, error = %s", l.GetName(), idx, err)
+		}
+
+		if len(f.Tags)%2 != 0 {
+			return fmt.Errorf("invalid feature: layer = %s, feature = %d, error = %s", l.GetName(), idx, ErrInvalidTags)
+		}
+
+		for t := 0; t < len(f.Tags); t += 2 {
+			if int(f.Tags[t]) >= len(l.Keys) || int(f.Tags[t+1]) >= len(l.Values) {
+				return fmt.Errorf("invalid feature: layer = %s, feature = %d, error = %s", l.GetName(), idx, ErrInvalidTags)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the geometry of a single feature (see section 4.3.4 of the specification):
+//
+//   - points are a single MoveTo of one or more points
+//   - lines are one or more MoveTo(1) LineTo(n) sequences
+//   - polygons are one or more MoveTo(1) LineTo(n >= 2) ClosePath rings with a non zero area, starting with an exterior
+//     ring (positive area)
+//
+// LineTo commands must not repeat the current point.
+func Validate(f *Tile_Feature) error {
+	if len(f.Geometry) == 0 {
+		return ErrEmptyGeometry
+	}
+
+	switch f.GetType() {
+	case Tile_POINT:
+		id, count := f.Geometry[0]&0x7, int(f.Geometry[0]>>3)
+		if id != cmdMoveTo || count == 0 || len(f.Geometry) != 1+count*2 {
+			return ErrInvalidGeometry
+		}
+		return nil
+
+	case Tile_LINESTRING, Tile_POLYGON:
+		return validatePaths(f.GetType(), f.Geometry)
+	}
+
+	return ErrUnsupportedGeometry
+}
+
+func validatePaths(gtype Tile_GeomType, cmds []uint32) error {
+	polygon := gtype == Tile_POLYGON
+	first := true
+
+	for idx := 0; idx < len(cmds); {
+		// MoveTo(1)
+		if cmds[idx] != command(cmdMoveTo, 1) || idx+3 > len(cmds) {
+			return ErrInvalidGeometry
+		}
+
+		// the ring is tracked relative to its start, which doesn't change the area
+		var x, y int32
+		ring := [][2]int32{{0, 0}}
+		idx += 3
+
+		// LineTo(n)
+		if idx >= len(cmds) || cmds[idx]&0x7 != cmdLineTo {
+			return ErrInvalidGeometry
+		}
+
+		count := int(cmds[idx] >> 3)
+		idx++
+
+		if count == 0 || (polygon && count < 2) || idx+count*2 > len(cmds) {
+			return ErrInvalidGeometry
+		}
+
+		for c := 0; c < count; c++ {
+			dx, dy := unzigzag(cmds[idx]), unzigzag(cmds[idx+1])
+			idx += 2
+
+			if dx == 0 && dy == 0 {
+				return ErrInvalidGeometry
+			}
+
+			x += dx
+			y += dy
+			ring = append(ring, [2]int32{x, y})
+		}
+
+		if !polygon {
+			continue
+		}
+
+		// ClosePath
+		if idx >= len(cmds) || cmds[idx] != command(cmdClosePath, 1) {
+			return ErrInvalidGeometry
+		}
+		idx++
+
+		area := Area(ring)
+
+		if area == 0 || (first && area < 0) {
+			return ErrInvalidGeometry
+		}
+
+		first = false
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package vtile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func feature(gtype Tile_GeomType, cmds ...uint32) *Tile_Feature {
+	return &Tile_Feature{Type: &gtype, Geometry: cmds}
+}
+
+func TestValidate(t *testing.T) {
+	// examples from the specification
+	valid := []*Tile_Feature{
+		feature(Tile_POINT, 9, 50, 34),
+		feature(Tile_POINT, 17, 10, 14, 3, 9),
+		feature(Tile_LINESTRING, 9, 4, 4, 18, 0, 16, 16, 0),
+		feature(Tile_LINESTRING, 9, 4, 4, 18, 0, 16, 16, 0, 9, 17, 17, 10, 4, 8),
+		feature(Tile_POLYGON, 9, 6, 12, 18, 10, 12, 24, 44, 15),
+		feature(Tile_POLYGON,
+			9, 0, 0, 26, 20, 0, 0, 20, 19, 0, 15,
+			9, 22, 2, 26, 18, 0, 0, 18, 17, 0, 15,
+			9, 4, 13, 26, 0, 8, 8, 0, 0, 7, 15,
+		),
+	}
+
+	for _, f := range valid {
+		require.NoError(t, Validate(f), "%v", f.Geometry)
+	}
+
+	invalid := []*Tile_Feature{
+		feature(Tile_POINT),
+		feature(Tile_POINT, 9, 50),
+		feature(Tile_POINT, 9, 50, 34, 15),
+		feature(Tile_LINESTRING, 9, 4, 4),
+		feature(Tile_LINESTRING, 9, 4, 4, 18, 0, 0, 16, 0),
+		feature(Tile_LINESTRING, 17, 4, 4, 2, 2, 10, 0, 16),
+		feature(Tile_POLYGON, 9, 6, 12, 10, 12, 24, 15),
+		feature(Tile_POLYGON, 9, 6, 12, 18, 10, 12, 24, 44),
+		// zero area
+		feature(Tile_POLYGON, 9, 0, 0, 18, 2, 2, 2, 2, 15),
+		// starts with an interior ring
+		feature(Tile_POLYGON, 9, 4, 13, 26, 0, 8, 8, 0, 0, 7, 15),
+		feature(Tile_UNKNOWN, 9, 50, 34),
+	}
+
+	for _, f := range invalid {
+		require.Error(t, Validate(f), "%v", f.Geometry)
+	}
+}
+
+func TestValidateLayer(t *testing.T) {
+	name := "water"
+	version := uint32(2)
+	extent := uint32(4096)
+	str := "lake"
+
+	layer := &Tile_Layer{
+		Name:     &name,
+		Version:  &version,
+		Extent:   &extent,
+		Keys:     []string{"type"},
+		Values:   []*Tile_Value{{StringValue: &str}},
+		Features: []*Tile_Feature{feature(Tile_POINT, 9, 50, 34)},
+	}
+
+	layer.Features[0].Tags = []uint32{0, 0}
+	require.NoError(t, ValidateLayer(layer))
+
+	layer.Features[0].Tags = []uint32{0, 1}
+	require.Error(t, ValidateLayer(layer))
+
+	layer.Features[0].Tags = []uint32{0}
+	require.Error(t, ValidateLayer(layer))
+
+	layer.Features[0].Tags = nil
+	layer.Values = []*Tile_Value{{}}
+	require.Error(t, ValidateLayer(layer))
+}